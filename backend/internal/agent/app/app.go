@@ -18,7 +18,7 @@ func Run() {
 		slog.Info("not correct .env variable: MAX_GOROUTINE_PER_AGENT")
 		max = 10
 	}
-	p := pool.New(max)
+	p := pool.New(max, os.Getenv("WORKER_NAME"))
 	defer p.Shutdown()
 	conn := redis.NewConnectionRedis()
 	defer redis.CloseConnectionRedis(conn)
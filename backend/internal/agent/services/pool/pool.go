@@ -16,7 +16,7 @@ import (
 
 // Worker Интерфейс надо реализовать объектам, которые будут обрабатываться параллельно
 type Worker interface {
-	Task(operTimeouts map[string]time.Duration) float64
+	Task(operTimeouts map[string]time.Duration) (interface{}, error)
 }
 
 // Pool Пул для выполнения
@@ -25,24 +25,40 @@ type Pool struct {
 	tasks   chan Worker
 	Results chan struct {
 		OperationID string
-		Res         float64
+		Res         interface{}
+		Error       string
+		ErrorCode   string
+		WorkerName  string
+		StartedAt   time.Time
+		FinishedAt  time.Time
 	}
 	timeouts map[string]time.Duration
+	// имя агента, которое передаётся вместе с результатами
+	workerName string
+	// число горутин, т.е. сколько операций агент вычисляет одновременно
+	maxGoroutines int
 	// для синхронизации работы
 	wg         sync.WaitGroup
 	mu         sync.Mutex
 	countTasks atomic.Int32
 }
 
-// New при создании пула передадим максимальное количество горутин
-func New(maxGoroutines int) *Pool {
+// New при создании пула передадим максимальное количество горутин и имя агента
+func New(maxGoroutines int, workerName string) *Pool {
 	p := Pool{
 		tasks: make(chan Worker), // канал, откуда брать задачи
 		Results: make(chan struct {
 			OperationID string
-			Res         float64
+			Res         interface{}
+			Error       string
+			ErrorCode   string
+			WorkerName  string
+			StartedAt   time.Time
+			FinishedAt  time.Time
 		}),
-		countTasks: atomic.Int32{},
+		countTasks:    atomic.Int32{},
+		workerName:    workerName,
+		maxGoroutines: maxGoroutines,
 	}
 	// для ожидания завершения
 	p.wg.Add(maxGoroutines)
@@ -54,11 +70,22 @@ func New(maxGoroutines int) *Pool {
 				// и выполняем
 				p.countTasks.Add(1)
 				operationID := w.(calc.Operation).OperationID
-				res := w.Task(p.timeouts)
+				startedAt := time.Now()
+				res, err := w.Task(p.timeouts)
+				finishedAt := time.Now()
+				errText, errCode := "", ""
+				if err != nil {
+					errText, errCode = err.Error(), calc.ErrorCode(err, calc.CodeCalculationError)
+				}
 				p.Results <- struct {
 					OperationID string
-					Res         float64
-				}{OperationID: operationID, Res: res}
+					Res         interface{}
+					Error       string
+					ErrorCode   string
+					WorkerName  string
+					StartedAt   time.Time
+					FinishedAt  time.Time
+				}{OperationID: operationID, Res: res, Error: errText, ErrorCode: errCode, WorkerName: p.workerName, StartedAt: startedAt, FinishedAt: finishedAt}
 				p.countTasks.Add(-1)
 			}
 			// после закрытия канала нужно оповестить наш пул
@@ -91,7 +118,8 @@ func (p *Pool) SendHearthbeat(workerName string, tick time.Duration) {
 		hearthbeat := struct {
 			WorkerName       string `json:"workerName"`
 			TaskCountCurrent int    `json:"taskCountCurrent"`
-		}{WorkerName: workerName, TaskCountCurrent: int(p.countTasks.Load())}
+			TaskCountMax     int    `json:"taskCountMax"`
+		}{WorkerName: workerName, TaskCountCurrent: int(p.countTasks.Load()), TaskCountMax: p.maxGoroutines}
 		data, _ := json.Marshal(hearthbeat)
 		r := bytes.NewReader(data)
 		resp, err := http.Post(fmt.Sprintf("http://%s:%s/getHearthbeat", os.Getenv("ORCHESTRATOR_ADDRESS"), os.Getenv("ORCHESTRATOR_PORT")), "application/json", r)
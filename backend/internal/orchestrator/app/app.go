@@ -54,12 +54,24 @@ func Run() {
 	go d.SendOperations(2 * time.Second)
 	go d.GetOperationResult()
 	go d.UpdateOperations(2 * time.Second)
+	go d.ResolveConditions(2 * time.Second)
+	go d.UpdateJobs(2 * time.Second)
 	go d.RestoreStuckedOperation(1 * time.Minute)
 	// Создаём http-сервер
 	router := mux.NewRouter()
 	router.HandleFunc("/addExpression", h.AuthMW(h.AddExpression))
+	router.HandleFunc("/planExpression", h.AuthMW(h.PlanExpression))
+	router.HandleFunc("/differentiateExpression", h.AuthMW(h.DifferentiateExpression))
 	router.HandleFunc("/getExpressionsList", h.AuthMW(h.GetExpressionsList))
 	router.HandleFunc("/getExpressionByID", h.AuthMW(h.GetExpressionByID))
+	router.HandleFunc("/cancelExpression", h.AuthMW(h.CancelExpression))
+	router.HandleFunc("/getExpressionOperations", h.AuthMW(h.GetExpressionOperations))
+	router.HandleFunc("/getExpressionTimeline", h.AuthMW(h.GetExpressionTimeline))
+	router.HandleFunc("/getExpressionGraph", h.AuthMW(h.GetExpressionGraph))
+	router.HandleFunc("/addRootJob", h.AuthMW(h.AddRootJob))
+	router.HandleFunc("/getRootJobByID", h.AuthMW(h.GetRootJobByID))
+	router.HandleFunc("/addSweepJob", h.AuthMW(h.AddSweepJob))
+	router.HandleFunc("/getSweepJobByID", h.AuthMW(h.GetSweepJobByID))
 	router.HandleFunc("/register", h.Registration)
 	router.HandleFunc("/login", h.Login)
 	router.HandleFunc("/setOperationsTimeout", h.AuthMW(h.SetOperationsTimeout))
@@ -3,6 +3,8 @@ package distributor
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"sync"
 	"time"
@@ -35,14 +37,15 @@ func (d *Distributor) NewOperations(tick time.Duration) {
 		}
 		slog.Info("Starting seporation of expression")
 		wg := &sync.WaitGroup{}
-		for _, row := range rows { // row[0] - expressionId, row[1] - expression
+		for _, row := range rows {
 			wg.Add(1)
-			go func(row []string) {
+			go func(row database.ExpressionToPlan) {
 				defer wg.Done()
-				tasks, err := calc.TransformExpressionToStack(row[0], row[1])
+				tasks, err := calc.TransformExpressionToStack(row.ExpressionID, row.Expression, calc.Options{Variables: row.Variables, Mode: row.Mode, Balance: row.Balance})
 				if err != nil {
 					slog.Warn(err.Error())
-					err = d.PostgresConn.ChangeExpressionStatus(context.Background(), row[0], -1)
+					reason := database.ExpressionError{Code: calc.ErrorCode(err, calc.CodeInvalidExpression), Message: err.Error()}
+					err = d.PostgresConn.SetExpressionFailed(context.Background(), row.ExpressionID, reason)
 					if err != nil {
 						slog.Warn(err.Error())
 					}
@@ -53,7 +56,7 @@ func (d *Distributor) NewOperations(tick time.Duration) {
 					slog.Warn(err.Error())
 					return
 				}
-				err = d.PostgresConn.ChangeExpressionStatus(context.Background(), row[0], 1)
+				err = d.PostgresConn.ChangeExpressionStatus(context.Background(), row.ExpressionID, database.ExpressionPlanned)
 				if err != nil {
 					slog.Warn(err.Error())
 				}
@@ -103,7 +106,16 @@ func (d *Distributor) SendOperations(tick time.Duration) {
 			slog.Warn(err.Error())
 			continue
 		}
-		err = d.PostgresConn.BulkChangeStatusOperations(context.Background(), 1, avalibleOperations)
+		err = d.PostgresConn.BulkChangeStatusOperations(context.Background(), database.OperationSent, avalibleOperations)
+		if err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
+		expressionIDs := make([]string, 0, len(avalibleOperations))
+		for _, oper := range avalibleOperations {
+			expressionIDs = append(expressionIDs, oper.ExpressionID)
+		}
+		err = d.PostgresConn.SetExpressionsRunning(context.Background(), expressionIDs)
 		if err != nil {
 			slog.Warn(err.Error())
 			continue
@@ -121,10 +133,34 @@ func (d *Distributor) GetOperationResult() {
 		}
 		var operation struct {
 			OperationID string
-			Res         float64
+			Res         interface{}
+			Error       string
+			ErrorCode   string
+			WorkerName  string
+			StartedAt   time.Time
+			FinishedAt  time.Time
 		}
 		json.Unmarshal([]byte(msg.Payload), &operation)
-		err = d.PostgresConn.SetOperationResult(context.Background(), operation.OperationID, operation.Res)
+		execution := database.OperationExecution{Worker: operation.WorkerName, StartedAt: operation.StartedAt, FinishedAt: operation.FinishedAt}
+		if operation.Error != "" {
+			slog.Warn(fmt.Sprintf("operation %s failed: %s", operation.OperationID, operation.Error))
+			expressionID, err := d.PostgresConn.SetOperationFailed(context.Background(), operation.OperationID, operation.Error, execution)
+			if err != nil {
+				slog.Warn(err.Error())
+				continue
+			}
+			code := operation.ErrorCode
+			if code == "" {
+				code = calc.CodeCalculationError
+			}
+			reason := database.ExpressionError{Code: code, Message: operation.Error, OperationID: &operation.OperationID}
+			err = d.PostgresConn.SetExpressionFailed(context.Background(), expressionID, reason)
+			if err != nil {
+				slog.Warn(err.Error())
+			}
+			continue
+		}
+		err = d.PostgresConn.SetOperationResult(context.Background(), operation.OperationID, operation.Res, execution)
 		if err != nil {
 			slog.Warn(err.Error())
 		}
@@ -134,59 +170,101 @@ func (d *Distributor) GetOperationResult() {
 func (d *Distributor) UpdateOperations(tick time.Duration) {
 	ticker := time.NewTicker(tick)
 	for range ticker.C {
-		operations, err := d.PostgresConn.GetComplitedOperation(context.Background())
+		err := updateOperations(context.Background(), d.PostgresConn)
 		if err != nil {
 			slog.Warn(err.Error())
-			continue
 		}
-		opList := make([]struct {
-			Operationid string
-			Parentid    string
-			Res         float64
-			Left        bool
-		}, 0)
-		var notFinalOperations = make([]calc.Operation, 0)
-		for _, operation := range operations {
-			if operation.ExpressionID == operation.ParentID {
-				err := d.PostgresConn.SetExpressionResult(context.Background(), operation.ExpressionID, operation.Result.(float64))
-				if err != nil {
-					slog.Warn(err.Error())
-					continue
-				}
-				err = d.PostgresConn.ChangeExpressionStatus(context.Background(), operation.ExpressionID, 2)
-				if err != nil {
-					slog.Warn(err.Error())
-					continue
-				}
-				err = d.PostgresConn.ChangeOperationStatus(context.Background(), operation.OperationID, 2)
-				if err != nil {
-					slog.Warn(err.Error())
+	}
+}
+
+// Часть database.Connection, через которую результаты операций передаются дальше
+type resultStore interface {
+	GetComplitedOperation(ctx context.Context) ([]calc.Operation, error)
+	SetExpressionResult(ctx context.Context, expressionid string, result interface{}) error
+	ChangeOperationStatus(ctx context.Context, operationid string, status database.OperationStatus) error
+	SetOperationResultToParent(ctx context.Context, opers []struct {
+		Operationid string
+		Parentid    string
+		Res         interface{}
+		Position    int
+	}) error
+	BulkChangeStatusOperations(ctx context.Context, status database.OperationStatus, operations []calc.Operation) error
+}
+
+// Передаёт результаты выполненных операций родителям, а результат корневой операции - выражению
+func updateOperations(ctx context.Context, store resultStore) error {
+	operations, err := store.GetComplitedOperation(ctx)
+	if err != nil {
+		return err
+	}
+	opList := make([]struct {
+		Operationid string
+		Parentid    string
+		Res         interface{}
+		Position    int
+	}, 0)
+	var notFinalOperations = make([]calc.Operation, 0)
+	for _, operation := range operations {
+		if len(operation.Parents) == 1 && operation.ExpressionID == operation.Parents[0].OperationID {
+			err := store.SetExpressionResult(ctx, operation.ExpressionID, operation.Result)
+			if err != nil {
+				slog.Warn(err.Error())
+				// Выражение уже завершено (например, отменено): результат отбрасывается,
+				// но операция всё равно закрывается, иначе её будут подбирать на каждом такте
+				if !errors.Is(err, database.ErrStatusTransition) {
 					continue
 				}
-				continue
 			}
+			err = store.ChangeOperationStatus(ctx, operation.OperationID, database.OperationDone)
+			if err != nil {
+				slog.Warn(err.Error())
+			}
+			continue
+		}
+		// Результат общего поддерева передаётся всем родителям
+		for _, parent := range operation.Parents {
 			op := struct {
 				Operationid string
 				Parentid    string
-				Res         float64
-				Left        bool
-			}{Operationid: operation.OperationID, Left: operation.Left, Parentid: operation.ParentID, Res: operation.Result.(float64)}
+				Res         interface{}
+				Position    int
+			}{Operationid: operation.OperationID, Position: parent.Position, Parentid: parent.OperationID, Res: operation.Result}
 			opList = append(opList, op)
-			notFinalOperations = append(notFinalOperations, operation)
 		}
-		err = d.PostgresConn.SetOperationResultToParent(context.Background(), opList)
+		notFinalOperations = append(notFinalOperations, operation)
+	}
+	err = store.SetOperationResultToParent(ctx, opList)
+	if err != nil {
+		return err
+	}
+	return store.BulkChangeStatusOperations(ctx, database.OperationDone, notFinalOperations)
+}
+
+// Выбирает ветви условных операций, условие которых вычислено. Операции невыбранной ветви не отправляются агентам.
+func (d *Distributor) ResolveConditions(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	for range ticker.C {
+		operations, err := d.PostgresConn.GetConditionsToResolve(context.Background())
 		if err != nil {
 			slog.Warn(err.Error())
 			continue
 		}
-		err = d.PostgresConn.BulkChangeStatusOperations(context.Background(), 2, notFinalOperations)
-		if err != nil {
-			slog.Warn(err.Error())
-			continue
+		for _, operation := range operations {
+			branch := 2
+			if calc.IsTrue(operation.Args[0]) {
+				branch = 1
+			}
+			err = d.PostgresConn.ResolveCondition(context.Background(), operation.OperationID, branch)
+			if err != nil {
+				slog.Warn(err.Error())
+			}
 		}
 	}
 }
 
+// Сколько раз зависшая операция отправляется агентам заново, прежде чем выражение завершится ошибкой calc.CodeTimedOut
+const maxOperationAttempts = 3
+
 func (d *Distributor) RestoreStuckedOperation(tick time.Duration) {
 	ticker := time.NewTicker(tick)
 	for range ticker.C {
@@ -206,7 +284,19 @@ func (d *Distributor) RestoreStuckedOperation(tick time.Duration) {
 				maxTimeout = max(maxTimeout, v)
 			}
 		}
-		err = d.PostgresConn.UpdateStuckedOperations(context.Background(), maxTimeout)
+		message := fmt.Sprintf("operation timed out %d times", maxOperationAttempts+1)
+		failed, err := d.PostgresConn.FailStuckedOperations(context.Background(), maxTimeout, maxOperationAttempts, message)
+		if err != nil {
+			slog.Warn(err.Error())
+		}
+		for _, operation := range failed {
+			reason := database.ExpressionError{Code: calc.CodeTimedOut, Message: message, OperationID: &operation.OperationID}
+			err = d.PostgresConn.SetExpressionFailed(context.Background(), operation.ExpressionID, reason)
+			if err != nil {
+				slog.Warn(err.Error())
+			}
+		}
+		err = d.PostgresConn.UpdateStuckedOperations(context.Background(), maxTimeout, maxOperationAttempts)
 		if err != nil {
 			slog.Warn(err.Error())
 			continue
@@ -0,0 +1,145 @@
+package distributor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/klef99/distributed-calculation-backend/pkg/calc"
+	"github.com/klef99/distributed-calculation-backend/pkg/database"
+)
+
+type fakeOperation struct {
+	op     calc.Operation
+	status database.OperationStatus
+}
+
+// Хранилище в памяти с теми же условиями выборки и переходов, что и запросы database.Connection
+type fakeStore struct {
+	expressions map[string]database.ExpressionStatus
+	operations  map[string]*fakeOperation
+	results     map[string]interface{}
+	// Вызывается после выборки операций: имитирует отмену выражения в это время
+	afterSelect func()
+}
+
+func (s *fakeStore) GetComplitedOperation(ctx context.Context) ([]calc.Operation, error) {
+	res := []calc.Operation{}
+	for _, o := range s.operations {
+		status := s.expressions[o.op.ExpressionID]
+		if o.status == database.OperationSent && o.op.Result != nil && (status == database.ExpressionPlanned || status == database.ExpressionRunning) {
+			res = append(res, o.op)
+		}
+	}
+	if s.afterSelect != nil {
+		s.afterSelect()
+	}
+	return res, nil
+}
+
+func (s *fakeStore) SetExpressionResult(ctx context.Context, expressionid string, result interface{}) error {
+	status := s.expressions[expressionid]
+	if status != database.ExpressionPlanned && status != database.ExpressionRunning {
+		return fmt.Errorf("expression %s to %s: %w", expressionid, database.ExpressionSucceeded, database.ErrStatusTransition)
+	}
+	s.expressions[expressionid] = database.ExpressionSucceeded
+	s.results[expressionid] = result
+	return nil
+}
+
+func (s *fakeStore) ChangeOperationStatus(ctx context.Context, operationid string, status database.OperationStatus) error {
+	o := s.operations[operationid]
+	if status != database.OperationDone || o.status != database.OperationSent {
+		return fmt.Errorf("operation %s to %d: %w", operationid, status, database.ErrStatusTransition)
+	}
+	o.status = status
+	return nil
+}
+
+func (s *fakeStore) SetOperationResultToParent(ctx context.Context, opers []struct {
+	Operationid string
+	Parentid    string
+	Res         interface{}
+	Position    int
+}) error {
+	for _, op := range opers {
+		s.operations[op.Parentid].op.Args[op.Position] = op.Res
+	}
+	return nil
+}
+
+func (s *fakeStore) BulkChangeStatusOperations(ctx context.Context, status database.OperationStatus, operations []calc.Operation) error {
+	for _, op := range operations {
+		s.operations[op.OperationID].status = status
+	}
+	return nil
+}
+
+func TestUpdateOperationsCancelledWhileRootInFlight(t *testing.T) {
+	store := &fakeStore{
+		expressions: map[string]database.ExpressionStatus{"e": database.ExpressionRunning},
+		operations: map[string]*fakeOperation{
+			"root": {op: calc.Operation{OperationID: "root", ExpressionID: "e", Parents: []calc.Parent{{OperationID: "e"}}, Result: 3.0}, status: database.OperationSent},
+		},
+		results: map[string]interface{}{},
+	}
+	store.afterSelect = func() {
+		store.expressions["e"] = database.ExpressionCancelled
+		store.afterSelect = nil
+	}
+	if err := updateOperations(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.expressions["e"]; got != database.ExpressionCancelled {
+		t.Errorf("expression status = %s, want cancelled", got)
+	}
+	if _, ok := store.results["e"]; ok {
+		t.Errorf("result of cancelled expression was stored")
+	}
+	if got := store.operations["root"].status; got != database.OperationDone {
+		t.Errorf("root operation status = %s, want done", got)
+	}
+	ops, _ := store.GetComplitedOperation(context.Background())
+	if len(ops) != 0 {
+		t.Errorf("root operation is picked up again: %v", ops)
+	}
+}
+
+func TestUpdateOperationsSkipsCancelledExpression(t *testing.T) {
+	store := &fakeStore{
+		expressions: map[string]database.ExpressionStatus{"e": database.ExpressionCancelled},
+		operations: map[string]*fakeOperation{
+			"root":  {op: calc.Operation{OperationID: "root", ExpressionID: "e", Parents: []calc.Parent{{OperationID: "e"}}, Args: []interface{}{nil, 2.0}}, status: database.OperationWaiting},
+			"child": {op: calc.Operation{OperationID: "child", ExpressionID: "e", Parents: []calc.Parent{{OperationID: "root"}}, Result: 1.0}, status: database.OperationSent},
+		},
+		results: map[string]interface{}{},
+	}
+	if err := updateOperations(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.operations["root"].op.Args[0]; got != nil {
+		t.Errorf("result of cancelled expression was passed to parent: %v", got)
+	}
+}
+
+func TestUpdateOperationsRootResult(t *testing.T) {
+	store := &fakeStore{
+		expressions: map[string]database.ExpressionStatus{"e": database.ExpressionRunning},
+		operations: map[string]*fakeOperation{
+			"root": {op: calc.Operation{OperationID: "root", ExpressionID: "e", Parents: []calc.Parent{{OperationID: "e"}}, Result: 3.0}, status: database.OperationSent},
+		},
+		results: map[string]interface{}{},
+	}
+	if err := updateOperations(context.Background(), store); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.expressions["e"]; got != database.ExpressionSucceeded {
+		t.Errorf("expression status = %s, want succeeded", got)
+	}
+	if got := store.results["e"]; got != 3.0 {
+		t.Errorf("expression result = %v, want 3", got)
+	}
+	if got := store.operations["root"].status; got != database.OperationDone {
+		t.Errorf("root operation status = %s, want done", got)
+	}
+}
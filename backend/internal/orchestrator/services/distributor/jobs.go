@@ -0,0 +1,207 @@
+package distributor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/roots"
+	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/sweep"
+	"github.com/klef99/distributed-calculation-backend/pkg/calc"
+	"github.com/klef99/distributed-calculation-backend/pkg/database"
+)
+
+// Продвигает незавершённые задания: когда выражения текущего шага вычислены, создаёт выражения следующего.
+// Выражения заданий разбиваются на операции и отправляются агентам так же, как выражения пользователей.
+func (d *Distributor) UpdateJobs(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	for range ticker.C {
+		jobs, err := d.PostgresConn.GetActiveJobs(context.Background())
+		if err != nil {
+			slog.Warn(err.Error())
+			continue
+		}
+		for _, job := range jobs {
+			// Выражения задания создаются от имени его владельца
+			ctx := context.WithValue(context.Background(), "userid", job.UserID)
+			switch job.Kind {
+			case database.JobRoot:
+				err = d.updateRootJob(ctx, job)
+			case database.JobSweep:
+				err = d.updateSweepJob(ctx, job)
+			default:
+				err = fmt.Errorf("job %s has unknown kind %q", job.JobID, job.Kind)
+			}
+			if err != nil {
+				slog.Warn(err.Error())
+			}
+		}
+	}
+}
+
+func (d *Distributor) updateRootJob(ctx context.Context, job database.Job) error {
+	params := roots.Params{}
+	err := json.Unmarshal(job.Params, &params)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.JobID, err)
+	}
+	progress := roots.Progress{}
+	if job.Progress != nil {
+		err = json.Unmarshal(job.Progress, &progress)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.JobID, err)
+		}
+	}
+	if progress.Pending() {
+		exprs, err := d.PostgresConn.GetJobExpressions(ctx, job.JobID)
+		if err != nil {
+			return err
+		}
+		for i, point := range progress.Points {
+			if point.Fx != nil {
+				continue
+			}
+			expr, ok := exprs[point.ExpressionID]
+			if !ok {
+				return d.failJob(ctx, job, progress, database.ExpressionError{Code: calc.CodeCalculationError, Message: fmt.Sprintf("expression %s of f(%g) didn't exist", point.ExpressionID, point.X)})
+			}
+			switch expr.Status {
+			case database.ExpressionSucceeded:
+				fx := calc.ApproximateValue(expr.Result)
+				progress.Points[i].Fx = &fx
+			case database.ExpressionFailed, database.ExpressionCancelled:
+				return d.failJob(ctx, job, progress, evaluationError(point.X, expr))
+			default:
+				// Значение ещё вычисляется
+				return nil
+			}
+		}
+	}
+	xs, err := progress.Next(params)
+	if err != nil {
+		return d.failJob(ctx, job, progress, database.ExpressionError{Code: roots.CodeRootNotFound, Message: err.Error()})
+	}
+	if progress.Done() {
+		return d.saveJob(ctx, job, database.ExpressionSucceeded, progress, nil)
+	}
+	exprs, ids := jobExpressions(job, xs)
+	progress.Add(xs, ids)
+	return d.saveJobStep(ctx, job, exprs, progress)
+}
+
+// Перебор: собирает значения вычисленных точек и создаёт выражения следующих, пока их не больше sweep.MaxInFlight.
+// Ошибка в точке не останавливает перебор, она записывается в строку таблицы.
+func (d *Distributor) updateSweepJob(ctx context.Context, job database.Job) error {
+	params := sweep.Params{}
+	err := json.Unmarshal(job.Params, &params)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.JobID, err)
+	}
+	progress := sweep.Progress{}
+	if job.Progress != nil {
+		err = json.Unmarshal(job.Progress, &progress)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", job.JobID, err)
+		}
+	}
+	changed := progress.Points == nil
+	progress.Init(params)
+	if progress.Count(sweep.PointCalculating) > 0 {
+		exprs, err := d.PostgresConn.GetJobExpressions(ctx, job.JobID)
+		if err != nil {
+			return err
+		}
+		for i, point := range progress.Points {
+			if point.State != sweep.PointCalculating {
+				continue
+			}
+			expr, ok := exprs[point.ExpressionID]
+			switch {
+			case !ok:
+				message := fmt.Sprintf("expression %s didn't exist", point.ExpressionID)
+				progress.Points[i].State, progress.Points[i].Error = sweep.PointFailed, &message
+			case expr.Status == database.ExpressionSucceeded:
+				value := calc.ApproximateValue(expr.Result)
+				progress.Points[i].State, progress.Points[i].Value = sweep.PointSucceeded, &value
+			case expr.Status == database.ExpressionFailed || expr.Status == database.ExpressionCancelled:
+				message := expr.State
+				if expr.Error != nil {
+					message = expr.Error.Message
+				}
+				progress.Points[i].State, progress.Points[i].Error = sweep.PointFailed, &message
+			default:
+				continue
+			}
+			changed = true
+		}
+	}
+	if progress.Done() {
+		return d.saveJob(ctx, job, database.ExpressionSucceeded, progress, nil)
+	}
+	indexes := progress.Schedule()
+	if !changed && len(indexes) == 0 {
+		return nil
+	}
+	xs := make([]float64, len(indexes))
+	for i, index := range indexes {
+		xs[i] = progress.Points[index].X
+	}
+	exprs, ids := jobExpressions(job, xs)
+	for i, index := range indexes {
+		progress.Points[index].State, progress.Points[index].ExpressionID = sweep.PointCalculating, ids[i]
+	}
+	return d.saveJobStep(ctx, job, exprs, progress)
+}
+
+// Выражения, вычисляющие шаблон задания при значениях xs его переменной, и их идентификаторы
+func jobExpressions(job database.Job, xs []float64) ([]database.Expression, []string) {
+	exprs := make([]database.Expression, 0, len(xs))
+	ids := make([]string, 0, len(xs))
+	for _, x := range xs {
+		variables := make(map[string]float64, len(job.Variables)+1)
+		for name, v := range job.Variables {
+			variables[name] = v
+		}
+		variables[job.Variable] = x
+		expr := database.Expression{Uuid: uuid.NewString(), Expr: job.Expression, Variables: variables, Mode: calc.ModeFloat, Balance: job.Balance, Format: calc.FormatInfix, JobID: &job.JobID}
+		exprs = append(exprs, expr)
+		ids = append(ids, expr.Uuid)
+	}
+	return exprs, ids
+}
+
+// Создаёт выражения следующего шага и сохраняет ход вычисления, который на них ссылается, одной транзакцией:
+// после ошибки в базе не остаётся выражений, о которых не знает задание
+func (d *Distributor) saveJobStep(ctx context.Context, job database.Job, exprs []database.Expression, progress interface{}) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.JobID, err)
+	}
+	return d.PostgresConn.InsertJobExpressions(ctx, job.JobID, exprs, data)
+}
+
+func (d *Distributor) saveJob(ctx context.Context, job database.Job, status database.ExpressionStatus, progress interface{}, reason *database.ExpressionError) error {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.JobID, err)
+	}
+	return d.PostgresConn.UpdateJob(ctx, job.JobID, status, data, reason)
+}
+
+func (d *Distributor) failJob(ctx context.Context, job database.Job, progress interface{}, reason database.ExpressionError) error {
+	slog.Warn(fmt.Sprintf("job %s failed: %s", job.JobID, reason.Message))
+	return d.saveJob(ctx, job, database.ExpressionFailed, progress, &reason)
+}
+
+// Причина ошибки задания по ошибке выражения, вычислявшего шаблон в точке x
+func evaluationError(x float64, expr database.Expression) database.ExpressionError {
+	reason := database.ExpressionError{Code: calc.CodeCalculationError, Message: fmt.Sprintf("evaluation at %g was %s", x, expr.State)}
+	if expr.Error != nil {
+		reason.Code = expr.Error.Code
+		reason.Message = fmt.Sprintf("evaluation at %g failed: %s", x, expr.Error.Message)
+	}
+	return reason
+}
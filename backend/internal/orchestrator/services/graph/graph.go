@@ -0,0 +1,179 @@
+package graph
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/klef99/distributed-calculation-backend/pkg/calc"
+	"github.com/klef99/distributed-calculation-backend/pkg/database"
+)
+
+// Форматы выгрузки дерева операций
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+)
+
+// Проверяет, что формат поддерживается
+func IsFormat(format string) bool {
+	return format == FormatDOT || format == FormatMermaid
+}
+
+// Цвета узлов по состоянию операции
+var operationColors = map[database.OperationStatus]string{
+	database.OperationWaiting: "#d9d9d9",
+	database.OperationSent:    "#ffd966",
+	database.OperationDone:    "#93c47d",
+	database.OperationFailed:  "#e06666",
+	database.OperationDormant: "#ffffff",
+}
+
+var expressionColors = map[database.ExpressionStatus]string{
+	database.ExpressionPending:   "#d9d9d9",
+	database.ExpressionPlanned:   "#d9d9d9",
+	database.ExpressionRunning:   "#ffd966",
+	database.ExpressionSucceeded: "#93c47d",
+	database.ExpressionFailed:    "#e06666",
+	database.ExpressionCancelled: "#b7b7b7",
+}
+
+// Узел графа: подпись из строк и цвет
+type node struct {
+	id    string
+	lines []string
+	color string
+	// Спящие операции (ветви ещё не вычисленных условий) рисуются пунктиром
+	dashed bool
+}
+
+type edge struct {
+	from, to string
+	label    string
+}
+
+// Рисует дерево операций выражения в формате format (FormatDOT или FormatMermaid).
+// Данные передаются снизу вверх: от операций с числами к узлу выражения.
+func Render(format string, expr database.Expression, ops []database.ExpressionOperation) (string, error) {
+	nodes, edges := build(expr, ops)
+	switch format {
+	case FormatDOT:
+		return renderDOT(nodes, edges), nil
+	case FormatMermaid:
+		return renderMermaid(nodes, edges), nil
+	}
+	return "", fmt.Errorf("unknown format %q", format)
+}
+
+func build(expr database.Expression, ops []database.ExpressionOperation) ([]node, []edge) {
+	ids := map[string]string{expr.Uuid: "expr"}
+	for i, op := range ops {
+		ids[op.OperationID] = fmt.Sprintf("op%d", i)
+	}
+	exprLines := []string{expr.Expr, expr.Status.String()}
+	if expr.Result != nil {
+		exprLines = append(exprLines, "= "+calc.FormatValue(expr.Result))
+	}
+	if expr.Error != nil {
+		exprLines = append(exprLines, expr.Error.Message)
+	}
+	nodes := []node{{id: "expr", lines: exprLines, color: expressionColors[expr.Status]}}
+	edges := []edge{}
+	for _, op := range ops {
+		state := database.OperationStatus(op.Status)
+		args := make([]string, len(op.Args))
+		for i, arg := range op.Args {
+			args[i] = "?"
+			if arg != nil {
+				args[i] = calc.FormatValue(arg)
+			}
+		}
+		lines := []string{op.Operator, strings.Join(args, ", ")}
+		if op.Result != nil {
+			lines = append(lines, "= "+calc.FormatValue(op.Result))
+		}
+		status := state.String()
+		if op.ChangedTime != nil {
+			status += " " + op.ChangedTime.Format("15:04:05")
+		}
+		lines = append(lines, status)
+		if op.Error != nil {
+			lines = append(lines, *op.Error)
+		}
+		nodes = append(nodes, node{id: ids[op.OperationID], lines: lines, color: operationColors[state], dashed: state == database.OperationDormant})
+		for _, parent := range op.Parents {
+			to, ok := ids[parent.OperationID]
+			if !ok {
+				continue
+			}
+			label := ""
+			if to != "expr" {
+				label = fmt.Sprint(parent.Position)
+			}
+			edges = append(edges, edge{from: ids[op.OperationID], to: to, label: label})
+		}
+	}
+	return nodes, edges
+}
+
+func renderDOT(nodes []node, edges []edge) string {
+	var b strings.Builder
+	b.WriteString("digraph expression {\n")
+	b.WriteString("    rankdir=BT;\n")
+	b.WriteString("    node [shape=box, style=filled, fontname=\"monospace\"];\n")
+	for _, n := range nodes {
+		lines := make([]string, len(n.lines))
+		for i, line := range n.lines {
+			lines[i] = dotEscape(line)
+		}
+		style := "filled"
+		if n.dashed {
+			style = "filled,dashed"
+		}
+		fmt.Fprintf(&b, "    %s [label=\"%s\", fillcolor=\"%s\", style=\"%s\"];\n", n.id, strings.Join(lines, "\\n"), n.color, style)
+	}
+	for _, e := range edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "    %s -> %s;\n", e.from, e.to)
+			continue
+		}
+		fmt.Fprintf(&b, "    %s -> %s [label=\"%s\"];\n", e.from, e.to, e.label)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+func renderMermaid(nodes []node, edges []edge) string {
+	var b strings.Builder
+	b.WriteString("flowchart BT\n")
+	for _, n := range nodes {
+		lines := make([]string, len(n.lines))
+		for i, line := range n.lines {
+			lines[i] = mermaidEscape(line)
+		}
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", n.id, strings.Join(lines, "<br/>"))
+	}
+	for _, e := range edges {
+		if e.label == "" {
+			fmt.Fprintf(&b, "    %s --> %s\n", e.from, e.to)
+			continue
+		}
+		fmt.Fprintf(&b, "    %s -->|%s| %s\n", e.from, e.label, e.to)
+	}
+	for _, n := range nodes {
+		style := fmt.Sprintf("fill:%s,stroke:#333", n.color)
+		if n.dashed {
+			style += ",stroke-dasharray:5 5"
+		}
+		fmt.Fprintf(&b, "    style %s %s\n", n.id, style)
+	}
+	return b.String()
+}
+
+// Mermaid понимает в подписях HTML-сущности вида #lt;
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "&", "#amp;").Replace(s)
+}
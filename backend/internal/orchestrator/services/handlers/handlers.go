@@ -2,16 +2,23 @@ package handlers
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/graph"
 	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/jwtgenerator"
+	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/roots"
+	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/sweep"
+	"github.com/klef99/distributed-calculation-backend/internal/orchestrator/services/timeline"
 	"github.com/klef99/distributed-calculation-backend/pkg/calc"
 	"github.com/klef99/distributed-calculation-backend/pkg/database"
 	"github.com/klef99/distributed-calculation-backend/pkg/redis"
@@ -19,9 +26,22 @@ import (
 )
 
 type Expression struct {
-	Expressionid string `json:"expressionid"`
-	Expr         string `json:"expression"`
-	Status       int    `json:"status"`
+	Expressionid string                    `json:"expressionid"`
+	Expr         string                    `json:"expression"`
+	Status       database.ExpressionStatus `json:"status"`
+	State        string                    `json:"state"`
+	Variables    map[string]float64        `json:"variables,omitempty"`
+	Mode         string                    `json:"mode"`
+	Balance      bool                      `json:"balance"`
+	Format       string                    `json:"format"`
+	Estimate
+}
+
+// Ожидаемое время вычисления выражения. Не возвращается, если нет работающих агентов или выражение уже завершено.
+type Estimate struct {
+	// Сколько секунд осталось до получения результата
+	EstimatedSeconds     *float64   `json:"estimatedSeconds,omitempty"`
+	ExpectedCompletionAt *time.Time `json:"expectedCompletionAt,omitempty"`
 }
 
 type Handler struct {
@@ -33,41 +53,63 @@ func New(db *database.Connection, red *redis.ConnectionRedis) Handler {
 	return Handler{conn: db, connR: red}
 }
 
-func (h *Handler) AddExpression(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-	exprs := struct {
-		Expression string `json:"expression"`
-	}{}
+// Тело запроса с выражением (addExpression, planExpression)
+type expressionRequest struct {
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables"`
+	Mode       string             `json:"mode"`
+	Balance    bool               `json:"balance"`
+	// Формат записи: infix (по умолчанию), rpn или prefix
+	Format string `json:"format"`
+}
+
+// Разбирает тело запроса и проверяет выражение. При ошибке ответ уже записан и возвращается false.
+func decodeExpression(w http.ResponseWriter, r *http.Request) (expressionRequest, string, bool) {
+	exprs := expressionRequest{}
 	dec := json.NewDecoder(r.Body)
 	err := dec.Decode(&exprs)
-	userid, _ := strconv.Atoi(r.Header.Get("userid"))
-	nctx := context.WithValue(r.Context(), "userid", userid)
 	if exprs.Expression == "" || err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		slog.Info("wrong decode expression")
-		return
+		return exprs, "", false
+	}
+	if exprs.Mode == "" {
+		exprs.Mode = calc.ModeFloat
 	}
-	expr, err := calc.ValidExpression(exprs.Expression)
+	if exprs.Format == "" {
+		exprs.Format = calc.FormatInfix
+	}
+	expr, err := calc.ValidExpression(exprs.Expression, calc.Options{Variables: exprs.Variables, Mode: exprs.Mode, Balance: exprs.Balance, Format: exprs.Format})
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
 		slog.Info(err.Error())
+		writeExpressionError(w, err)
+		return exprs, "", false
+	}
+	return exprs, expr, true
+}
+
+func (h *Handler) AddExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	exprs, expr, ok := decodeExpression(w, r)
+	if !ok {
 		return
 	}
 	expressionid := r.Header.Get("X-Request-Id")
 	if expressionid == "" {
 		expressionid = uuid.NewString()
 	}
-	_, _, _, err = h.conn.GetExpressionByID(nctx, expressionid)
+	_, err := h.conn.GetExpressionByID(nctx, expressionid)
 	if err == nil {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("Expression exist in database"))
 		return
 	}
-	res := Expression{Expressionid: expressionid, Expr: expr, Status: 0}
-	err = h.conn.InsertExpression(nctx, res.Expressionid, res.Expr)
+	res, err := h.saveExpression(nctx, userid, Expression{Expressionid: expressionid, Expr: expr, Variables: exprs.Variables, Mode: exprs.Mode, Balance: exprs.Balance, Format: exprs.Format})
 	w.Header().Set("Content-Type", "application/json")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -78,6 +120,188 @@ func (h *Handler) AddExpression(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// Сохраняет проверенное выражение со статусом pending и оценивает время его вычисления
+func (h *Handler) saveExpression(ctx context.Context, userid int, res Expression) (Expression, error) {
+	res.Status, res.State = database.ExpressionPending, database.ExpressionPending.String()
+	expr := database.Expression{Uuid: res.Expressionid, Expr: res.Expr, Variables: res.Variables, Mode: res.Mode, Balance: res.Balance, Format: res.Format}
+	err := h.conn.InsertExpression(ctx, expr)
+	if err != nil {
+		return res, err
+	}
+	res.Estimate = h.plannedEstimate(userid, expr)
+	return res, nil
+}
+
+// Тело запроса производной (differentiateExpression)
+type differentiateRequest struct {
+	Expression string `json:"expression"`
+	Format     string `json:"format"`
+	// Переменная дифференцирования, по умолчанию x
+	Variable string `json:"variable"`
+	// Точка, в которой вычисляется производная; без неё производная только возвращается
+	Variables map[string]float64 `json:"variables"`
+	Mode      string             `json:"mode"`
+	Balance   bool               `json:"balance"`
+}
+
+// Производная выражения по переменной. Если переданы значения переменных, производная
+// сохраняется как новое выражение и вычисляется агентами, как после addExpression.
+func (h *Handler) DifferentiateExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	req := differentiateRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if req.Expression == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		slog.Info("wrong decode expression")
+		return
+	}
+	if req.Format == "" {
+		req.Format = calc.FormatInfix
+	}
+	if req.Variable == "" {
+		req.Variable = "x"
+	}
+	if req.Mode == "" {
+		req.Mode = calc.ModeFloat
+	}
+	if !calc.IsFormat(req.Format) {
+		writeExpressionError(w, fmt.Errorf("unknown format %q", req.Format))
+		return
+	}
+	res := struct {
+		Expression string `json:"expression"`
+		Variable   string `json:"variable"`
+		Derivative string `json:"derivative"`
+		// Выражение, созданное для вычисления производной в точке variables
+		Evaluation *Expression `json:"evaluation,omitempty"`
+	}{Variable: req.Variable}
+	res.Expression, err = calc.CanonicalExpression(req.Expression, req.Format)
+	if err == nil {
+		res.Derivative, err = calc.DerivativeExpression(req.Expression, req.Format, req.Variable)
+	}
+	if err != nil {
+		slog.Info(err.Error())
+		writeExpressionError(w, err)
+		return
+	}
+	if req.Variables != nil {
+		expr, err := calc.ValidExpression(res.Derivative, calc.Options{Variables: req.Variables, Mode: req.Mode, Balance: req.Balance})
+		if err != nil {
+			slog.Info(err.Error())
+			writeExpressionError(w, err)
+			return
+		}
+		expressionid := r.Header.Get("X-Request-Id")
+		if expressionid == "" {
+			expressionid = uuid.NewString()
+		}
+		_, err = h.conn.GetExpressionByID(nctx, expressionid)
+		if err == nil {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("Expression exist in database"))
+			return
+		}
+		evaluation, err := h.saveExpression(nctx, userid, Expression{Expressionid: expressionid, Expr: expr, Variables: req.Variables, Mode: req.Mode, Balance: req.Balance, Format: calc.FormatInfix})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			slog.Warn(err.Error())
+			return
+		}
+		res.Evaluation = &evaluation
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Операция плана выражения
+type planOperation struct {
+	OperationID string `json:"operationid"`
+	Operator    string `json:"operator"`
+	// Операнды, null - результат другой операции
+	Args []interface{} `json:"args"`
+	// Условная операция и номер её ветви для операций внутри ветвей
+	Guard  string `json:"guard,omitempty"`
+	Branch int    `json:"branch,omitempty"`
+}
+
+// Передача результата операции From в операнд Position операции To
+type planEdge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Position int    `json:"position"`
+}
+
+// Показывает, как выражение будет разбито на операции, ничего не сохраняя
+func (h *Handler) PlanExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	exprs, expr, ok := decodeExpression(w, r)
+	if !ok {
+		return
+	}
+	expressionid := uuid.NewString()
+	res := struct {
+		Expression     string          `json:"expression"`
+		Mode           string          `json:"mode"`
+		Format         string          `json:"format"`
+		Root           string          `json:"root,omitempty"`
+		Operations     []planOperation `json:"operations"`
+		Edges          []planEdge      `json:"edges"`
+		Depth          int             `json:"depth"`
+		OperationCount int             `json:"operationCount"`
+		Estimate
+		// Ошибка, с которой завершится выражение, если его отправить (addExpression его примет)
+		Error *database.ExpressionError `json:"error,omitempty"`
+	}{Expression: expr, Mode: exprs.Mode, Format: exprs.Format, Operations: []planOperation{}, Edges: []planEdge{}}
+	w.Header().Set("Content-Type", "application/json")
+	tasks, err := calc.TransformExpressionToStack(expressionid, expr, calc.Options{Variables: exprs.Variables, Mode: exprs.Mode, Balance: exprs.Balance})
+	if err != nil {
+		res.Error = &database.ExpressionError{Code: calc.ErrorCode(err, calc.CodeInvalidExpression), Message: err.Error()}
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+	res.Depth, res.OperationCount = calc.Depth(tasks), len(tasks)
+	ops := make([]database.ExpressionOperation, len(tasks))
+	for i, task := range tasks {
+		ops[i] = database.ExpressionOperation{Operation: task}
+		res.Operations = append(res.Operations, planOperation{OperationID: task.OperationID, Operator: task.Operator, Args: task.Args, Guard: task.Guard, Branch: task.Branch})
+		for _, parent := range task.Parents {
+			if parent.OperationID == expressionid {
+				res.Root = task.OperationID
+				continue
+			}
+			res.Edges = append(res.Edges, planEdge{From: task.OperationID, To: parent.OperationID, Position: parent.Position})
+		}
+	}
+	res.Estimate = h.estimate(userid, ops)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Ответ 400 с описанием ошибки в выражении: для синтаксических ошибок указываются позиция и лексема
+func writeExpressionError(w http.ResponseWriter, err error) {
+	var syntaxErr *calc.SyntaxError
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if errors.As(err, &syntaxErr) {
+		json.NewEncoder(w).Encode(syntaxErr)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
+
 func (h *Handler) GetExpressionsList(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -93,6 +317,9 @@ func (h *Handler) GetExpressionsList(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	for i := range exprs {
+		exprs[i].Expr = displayExpression(exprs[i].Expr)
+	}
 	json.NewEncoder(w).Encode(exprs)
 }
 
@@ -102,15 +329,68 @@ func (h *Handler) GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	exprId := r.URL.Query().Get("expressionId")
+	render := r.URL.Query().Get("render")
+	if render != "" && render != calc.FormatLaTeX {
+		http.Error(w, "render must be latex", http.StatusBadRequest)
+		return
+	}
 	userid, _ := strconv.Atoi(r.Header.Get("userid"))
 	nctx := context.WithValue(r.Context(), "userid", userid)
-	expression, result, status, err := h.conn.GetExpressionByID(nctx, exprId)
+	expr, err := h.conn.GetExpressionByID(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err.Error() == "expression didn't exist" {
+			w.Write([]byte(err.Error()))
+		}
+		slog.Warn(err.Error())
+		return
+	}
+	expr.Expr = displayExpression(expr.Expr)
 	res := struct {
-		Uuid   string      `json:"expressionid"`
-		Expr   string      `json:"expression"`
-		Status int32       `json:"status"`
-		Result interface{} `json:"result"`
-	}{Result: result, Uuid: exprId, Status: status, Expr: expression}
+		database.Expression
+		Estimate
+		// Выражение и результат в LaTeX (только при render=latex)
+		LaTeX       string `json:"latex,omitempty"`
+		ResultLaTeX string `json:"resultLatex,omitempty"`
+	}{Expression: expr}
+	if render == calc.FormatLaTeX {
+		res.LaTeX, err = calc.ExpressionLaTeX(expr.Expr, calc.FormatInfix)
+		if err != nil {
+			slog.Warn(err.Error())
+		}
+		switch {
+		case expr.ExactResult != nil:
+			res.ResultLaTeX = calc.LaTeXValue(*expr.ExactResult)
+		case expr.Result != nil:
+			res.ResultLaTeX = calc.LaTeXValue(expr.Result)
+		}
+	}
+	switch expr.Status {
+	case database.ExpressionPending:
+		res.Estimate = h.plannedEstimate(userid, expr)
+	case database.ExpressionPlanned, database.ExpressionRunning:
+		ops, err := h.conn.GetExpressionOperations(nctx, exprId)
+		if err != nil {
+			slog.Warn(err.Error())
+			break
+		}
+		res.Estimate = h.estimate(userid, ops)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Отменяет выражение, которое ещё вычисляется
+func (h *Handler) CancelExpression(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	exprId := r.URL.Query().Get("expressionId")
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	_, err := h.conn.GetExpressionByID(nctx, exprId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		if err.Error() == "expression didn't exist" {
@@ -119,11 +399,559 @@ func (h *Handler) GetExpressionByID(w http.ResponseWriter, r *http.Request) {
 		slog.Warn(err.Error())
 		return
 	}
+	err = h.conn.CancelExpression(nctx, exprId)
+	if errors.Is(err, database.ErrStatusTransition) {
+		http.Error(w, "expression is already finished", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Expressionid string                    `json:"expressionid"`
+		Status       database.ExpressionStatus `json:"status"`
+		State        string                    `json:"state"`
+	}{Expressionid: exprId, Status: database.ExpressionCancelled, State: database.ExpressionCancelled.String()})
+}
+
+// Операция выражения с ходом её выполнения
+type expressionOperation struct {
+	OperationID string        `json:"operationid"`
+	Operator    string        `json:"operator"`
+	Args        []interface{} `json:"args"`
+	// Операции, которым передаётся результат; для корневой операции - само выражение
+	Parents []operationParent `json:"parents"`
+	Guard   string            `json:"guard,omitempty"`
+	Branch  int               `json:"branch,omitempty"`
+	Status  int               `json:"status"`
+	State   string            `json:"state"`
+	Result  interface{}       `json:"result"`
+	Error   *string           `json:"error"`
+	// Время отправки агентам, агент и время вычисления на нём
+	QueuedAt    *time.Time `json:"queuedAt"`
+	Worker      *string    `json:"worker"`
+	StartedAt   *time.Time `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt"`
+	ChangedTime *time.Time `json:"changedTime"`
+}
+
+// Передача результата в операнд Position родительской операции
+type operationParent struct {
+	OperationID string `json:"operationid"`
+	Position    int    `json:"position"`
+}
+
+// Операции выражения пользователя: промежуточные шаги вычисления
+func (h *Handler) GetExpressionOperations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	exprId := r.URL.Query().Get("expressionId")
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	_, err := h.conn.GetExpressionByID(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err.Error() == "expression didn't exist" {
+			w.Write([]byte(err.Error()))
+		}
+		slog.Warn(err.Error())
+		return
+	}
+	ops, err := h.conn.GetExpressionOperations(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return
+	}
+	res := make([]expressionOperation, len(ops))
+	for i, op := range ops {
+		parents := make([]operationParent, len(op.Parents))
+		for j, parent := range op.Parents {
+			parents[j] = operationParent{OperationID: parent.OperationID, Position: parent.Position}
+		}
+		res[i] = expressionOperation{
+			OperationID: op.OperationID, Operator: op.Operator, Args: op.Args, Parents: parents,
+			Guard: op.Guard, Branch: op.Branch, Status: op.Status, State: database.OperationStatus(op.Status).String(),
+			Result: op.Result, Error: op.Error,
+			QueuedAt: op.QueuedTime, Worker: op.Worker, StartedAt: op.StartedTime, FinishedAt: op.FinishedTime, ChangedTime: op.ChangedTime,
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(res)
 }
 
+// Фактический ход вычисления выражения: время каждой операции, критический путь и загрузка агентов
+func (h *Handler) GetExpressionTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	exprId := r.URL.Query().Get("expressionId")
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	expr, err := h.conn.GetExpressionByID(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err.Error() == "expression didn't exist" {
+			w.Write([]byte(err.Error()))
+		}
+		slog.Warn(err.Error())
+		return
+	}
+	ops, err := h.conn.GetExpressionOperations(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return
+	}
+	workers, err := h.connR.GetWorkersStatus(context.Background())
+	if err != nil {
+		slog.Warn(err.Error())
+	}
+	names := make([]string, 0, len(workers))
+	for _, worker := range workers {
+		names = append(names, worker.WorkerName)
+	}
+	res := struct {
+		Expressionid string                    `json:"expressionid"`
+		Status       database.ExpressionStatus `json:"status"`
+		State        string                    `json:"state"`
+		timeline.Timeline
+	}{Expressionid: expr.Uuid, Status: expr.Status, State: expr.Status.String(), Timeline: timeline.Build(ops, names)}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Дерево операций выражения в формате DOT или Mermaid
+func (h *Handler) GetExpressionGraph(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	exprId := r.URL.Query().Get("expressionId")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = graph.FormatDOT
+	}
+	if !graph.IsFormat(format) {
+		http.Error(w, "format must be dot or mermaid", http.StatusBadRequest)
+		return
+	}
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	expr, err := h.conn.GetExpressionByID(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err.Error() == "expression didn't exist" {
+			w.Write([]byte(err.Error()))
+		}
+		slog.Warn(err.Error())
+		return
+	}
+	expr.Expr = displayExpression(expr.Expr)
+	ops, err := h.conn.GetExpressionOperations(nctx, exprId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return
+	}
+	res, err := graph.Render(format, expr, ops)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return
+	}
+	if format == graph.FormatDOT {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(res))
+}
+
+// Общие поля тела запроса задания (addRootJob, addSweepJob)
+type jobRequest struct {
+	Expression string `json:"expression"`
+	Format     string `json:"format"`
+	// Переменная, значения которой подставляет оркестратор, по умолчанию x
+	Variable string `json:"variable"`
+	// Значения остальных переменных выражения
+	Variables map[string]float64 `json:"variables"`
+	Balance   bool               `json:"balance"`
+}
+
+// Проверяет выражение задания, связывая переменную задания значением value, и возвращает его каноническую запись.
+// При ошибке ответ уже записан и возвращается false.
+func (req *jobRequest) validate(w http.ResponseWriter, value float64) (string, bool) {
+	if req.Format == "" {
+		req.Format = calc.FormatInfix
+	}
+	if req.Variable == "" {
+		req.Variable = "x"
+	}
+	if !calc.IsVariableName(req.Variable) {
+		writeExpressionError(w, fmt.Errorf("invalid variable name %q", req.Variable))
+		return "", false
+	}
+	if _, ok := req.Variables[req.Variable]; ok {
+		writeExpressionError(w, fmt.Errorf("variable %q is set by the job and cannot be passed in variables", req.Variable))
+		return "", false
+	}
+	variables := map[string]float64{req.Variable: value}
+	for name, v := range req.Variables {
+		variables[name] = v
+	}
+	expr, err := calc.ValidExpression(req.Expression, calc.Options{Variables: variables, Mode: calc.ModeFloat, Balance: req.Balance, Format: req.Format})
+	if err != nil {
+		slog.Info(err.Error())
+		writeExpressionError(w, err)
+		return "", false
+	}
+	return expr, true
+}
+
+// Сохраняет задание с параметрами params. Id задания - заголовок X-Request-Id или новый uuid.
+// При ошибке или повторном запросе ответ уже записан и возвращается false.
+func (h *Handler) insertJob(ctx context.Context, w http.ResponseWriter, r *http.Request, job database.Job, params interface{}) (database.Job, bool) {
+	job.JobID = r.Header.Get("X-Request-Id")
+	if job.JobID == "" {
+		job.JobID = uuid.NewString()
+	}
+	_, err := h.conn.GetJobByID(ctx, job.JobID, job.Kind)
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Job exist in database"))
+		return job, false
+	}
+	job.Params, err = json.Marshal(params)
+	if err == nil {
+		job.Status = database.ExpressionPending
+		err = h.conn.InsertJob(ctx, job)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return job, false
+	}
+	return job, true
+}
+
+// Задание пользователя вида kind по параметру jobId. При ошибке ответ уже записан и возвращается false.
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request, kind string) (database.Job, bool) {
+	jobId := r.URL.Query().Get("jobId")
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	job, err := h.conn.GetJobByID(nctx, jobId, kind)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if err.Error() == "job didn't exist" {
+			w.Write([]byte(err.Error()))
+		}
+		slog.Warn(err.Error())
+		return job, false
+	}
+	return job, true
+}
+
+func writeJob(w http.ResponseWriter, res interface{}, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Warn(err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Тело запроса задания поиска корня (addRootJob)
+type rootJobRequest struct {
+	jobRequest
+	roots.Params
+}
+
+// Задание поиска корня с ходом вычисления
+type rootJob struct {
+	JobID      string             `json:"jobid"`
+	Expression string             `json:"expression"`
+	Variable   string             `json:"variable"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Balance    bool               `json:"balance"`
+	roots.Params
+	Status database.ExpressionStatus `json:"status"`
+	State  string                    `json:"state"`
+	roots.Progress
+	Error *database.ExpressionError `json:"error,omitempty"`
+}
+
+func newRootJob(job database.Job) (rootJob, error) {
+	res := rootJob{JobID: job.JobID, Expression: job.Expression, Variable: job.Variable, Variables: job.Variables, Balance: job.Balance, Status: job.Status, State: job.Status.String(), Error: job.Error}
+	err := json.Unmarshal(job.Params, &res.Params)
+	if err != nil {
+		return rootJob{}, err
+	}
+	if job.Progress != nil {
+		err = json.Unmarshal(job.Progress, &res.Progress)
+		if err != nil {
+			return rootJob{}, err
+		}
+	}
+	if res.Points == nil {
+		res.Points = []roots.Point{}
+	}
+	return res, nil
+}
+
+// Поиск корня f(x) = 0 на отрезке. Оркестратор делает шаги метода, вычисляя f в новых точках
+// выражениями, которые агенты считают как обычные.
+func (h *Handler) AddRootJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	req := rootJobRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if req.Expression == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		slog.Info("wrong decode expression")
+		return
+	}
+	err = req.Params.Validate()
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+	expr, ok := req.validate(w, req.A)
+	if !ok {
+		return
+	}
+	job, ok := h.insertJob(nctx, w, r, database.Job{Kind: database.JobRoot, Expression: expr, Variable: req.Variable, Variables: req.Variables, Balance: req.Balance}, req.Params)
+	if !ok {
+		return
+	}
+	res, err := newRootJob(job)
+	writeJob(w, res, err)
+}
+
+// Состояние задания поиска корня: вычисленные точки, текущий отрезок и найденный корень
+func (h *Handler) GetRootJobByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	job, ok := h.getJob(w, r, database.JobRoot)
+	if !ok {
+		return
+	}
+	res, err := newRootJob(job)
+	writeJob(w, res, err)
+}
+
+// Тело запроса задания перебора (addSweepJob)
+type sweepJobRequest struct {
+	jobRequest
+	sweep.Params
+}
+
+// Задание перебора с таблицей результатов
+type sweepJob struct {
+	JobID      string             `json:"jobid"`
+	Expression string             `json:"expression"`
+	Variable   string             `json:"variable"`
+	Variables  map[string]float64 `json:"variables,omitempty"`
+	Balance    bool               `json:"balance"`
+	sweep.Params
+	Status database.ExpressionStatus `json:"status"`
+	State  string                    `json:"state"`
+	// Число точек, из них вычисленных (успешно или с ошибкой) и завершившихся ошибкой
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	// Значения выражения в порядке возрастания переменной
+	Series []sweep.Point             `json:"series"`
+	Error  *database.ExpressionError `json:"error,omitempty"`
+}
+
+func newSweepJob(job database.Job) (sweepJob, error) {
+	res := sweepJob{JobID: job.JobID, Expression: job.Expression, Variable: job.Variable, Variables: job.Variables, Balance: job.Balance, Status: job.Status, State: job.Status.String(), Error: job.Error}
+	err := json.Unmarshal(job.Params, &res.Params)
+	if err != nil {
+		return sweepJob{}, err
+	}
+	progress := sweep.Progress{}
+	if job.Progress != nil {
+		err = json.Unmarshal(job.Progress, &progress)
+		if err != nil {
+			return sweepJob{}, err
+		}
+	}
+	// До первого шага оркестратора все точки ждут
+	progress.Init(res.Params)
+	res.Series = progress.Points
+	res.Total = len(progress.Points)
+	res.Failed = progress.Count(sweep.PointFailed)
+	res.Completed = progress.Count(sweep.PointSucceeded) + res.Failed
+	return res, nil
+}
+
+// Вычисление выражения на диапазоне значений переменной: from, from + step, ... до to.
+// Каждое значение - отдельное выражение, которые оркестратор отправляет агентам порциями.
+func (h *Handler) AddSweepJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	userid, _ := strconv.Atoi(r.Header.Get("userid"))
+	nctx := context.WithValue(r.Context(), "userid", userid)
+	req := sweepJobRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if req.Expression == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		slog.Info("wrong decode expression")
+		return
+	}
+	err = req.Params.Validate()
+	if err != nil {
+		writeExpressionError(w, err)
+		return
+	}
+	expr, ok := req.validate(w, req.From)
+	if !ok {
+		return
+	}
+	job, ok := h.insertJob(nctx, w, r, database.Job{Kind: database.JobSweep, Expression: expr, Variable: req.Variable, Variables: req.Variables, Balance: req.Balance}, req.Params)
+	if !ok {
+		return
+	}
+	res, err := newSweepJob(job)
+	writeJob(w, res, err)
+}
+
+// Таблица результатов перебора. Доступна во время вычисления: невычисленные значения - null.
+// format=csv - таблица в CSV для скачивания.
+func (h *Handler) GetSweepJobByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "format must be json or csv", http.StatusBadRequest)
+		return
+	}
+	job, ok := h.getJob(w, r, database.JobSweep)
+	if !ok {
+		return
+	}
+	res, err := newSweepJob(job)
+	if err != nil || format != "csv" {
+		writeJob(w, res, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"sweep-%s.csv\"", res.JobID))
+	w.WriteHeader(http.StatusOK)
+	table := csv.NewWriter(w)
+	table.Write([]string{res.Variable, "value", "state", "error"})
+	for _, point := range res.Series {
+		row := []string{calc.FormatValue(point.X), "", point.State, ""}
+		if point.Value != nil {
+			row[1] = calc.FormatValue(*point.Value)
+		}
+		if point.Error != nil {
+			row[3] = *point.Error
+		}
+		table.Write(row)
+	}
+	table.Flush()
+}
+
+// Каноническая запись сохранённого выражения. Выражения, сохранённые до появления канонической записи,
+// приводятся к ней при показе; если выражение не разбирается, оно показывается как есть.
+func displayExpression(expr string) string {
+	res, err := calc.CanonicalExpression(expr, calc.FormatInfix)
+	if err != nil {
+		return expr
+	}
+	return res
+}
+
+// Оценка для выражения, которое ещё не разбито на операции
+func (h *Handler) plannedEstimate(userid int, expr database.Expression) Estimate {
+	tasks, err := calc.TransformExpressionToStack(expr.Uuid, expr.Expr, calc.Options{Variables: expr.Variables, Mode: expr.Mode, Balance: expr.Balance})
+	if err != nil {
+		return Estimate{}
+	}
+	ops := make([]database.ExpressionOperation, len(tasks))
+	for i, task := range tasks {
+		ops[i] = database.ExpressionOperation{Operation: task}
+	}
+	return h.estimate(userid, ops)
+}
+
+// Время выполнения оставшихся операций по времени операций пользователя и числу мест для операций
+// на работающих агентах
+func (h *Handler) estimate(userid int, ops []database.ExpressionOperation) Estimate {
+	workers, err := h.connR.GetWorkersStatus(context.Background())
+	if err != nil {
+		slog.Warn(err.Error())
+		return Estimate{}
+	}
+	slots := 0
+	for _, worker := range workers {
+		if worker.Status != "OK" {
+			continue
+		}
+		// Агент, не сообщивший число горутин, считается выполняющим одну операцию за раз
+		capacity, err := strconv.Atoi(worker.TaskCountMax)
+		if err != nil || capacity < 1 {
+			capacity = 1
+		}
+		slots += capacity
+	}
+	if slots == 0 {
+		return Estimate{}
+	}
+	timeouts, err := h.connR.GetOperationsTimeouts(userid)
+	if err != nil {
+		slog.Warn(err.Error())
+		return Estimate{}
+	}
+	now := time.Now()
+	changed := make(map[string]*time.Time, len(ops))
+	tasks := make([]calc.Operation, len(ops))
+	for i, op := range ops {
+		tasks[i] = op.Operation
+		changed[op.OperationID] = op.ChangedTime
+	}
+	remaining := calc.Estimate(tasks, func(op calc.Operation) time.Duration {
+		switch database.OperationStatus(op.Status) {
+		case database.OperationDone, database.OperationFailed:
+			return 0
+		case database.OperationSent:
+			// Операция уже выполняется агентом
+			if t := changed[op.OperationID]; t != nil {
+				return timeouts[op.Operator] - now.Sub(*t)
+			}
+		}
+		return timeouts[op.Operator]
+	}, slots)
+	seconds := remaining.Seconds()
+	completion := now.Add(remaining)
+	return Estimate{EstimatedSeconds: &seconds, ExpectedCompletionAt: &completion}
+}
+
 func (h *Handler) GetHearthbeat(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -132,10 +960,11 @@ func (h *Handler) GetHearthbeat(w http.ResponseWriter, r *http.Request) {
 	hearthbeat := struct {
 		WorkerName       string `json:"workerName"`
 		TaskCountCurrent int    `json:"taskCountCurrent"`
+		TaskCountMax     int    `json:"taskCountMax"`
 	}{}
 	data, _ := io.ReadAll(r.Body)
 	json.Unmarshal(data, &hearthbeat)
-	h.connR.SetWorkerStatus(r.Context(), hearthbeat.WorkerName, hearthbeat.TaskCountCurrent)
+	h.connR.SetWorkerStatus(r.Context(), hearthbeat.WorkerName, hearthbeat.TaskCountCurrent, hearthbeat.TaskCountMax)
 	slog.Info(fmt.Sprintf("Get herathbeat from %s. Num of task: %d", hearthbeat.WorkerName, hearthbeat.TaskCountCurrent))
 	w.WriteHeader(http.StatusOK)
 }
@@ -237,7 +1066,7 @@ func (h *Handler) Registration(w http.ResponseWriter, r *http.Request) {
 		slog.Warn(err.Error())
 		return
 	}
-	err = h.connR.BulkSetOperationsTimeouts(map[string]int{"+": 10, "-": 10, "*": 10, "/": 10}, userid)
+	err = h.connR.BulkSetOperationsTimeouts(calc.DefaultTimeouts(), userid)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		slog.Warn(err.Error())
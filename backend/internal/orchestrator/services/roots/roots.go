@@ -0,0 +1,200 @@
+package roots
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Методы поиска корня
+const (
+	// Деление отрезка пополам: значения на концах отрезка должны иметь разные знаки
+	MethodBisection = "bisection"
+	// Метод секущих: приближения строятся по двум последним точкам и могут выйти за отрезок
+	MethodSecant = "secant"
+)
+
+// Код ошибки задания, если поиск нельзя продолжить: нет смены знака на отрезке, секущая не определена
+const CodeRootNotFound = "root_not_found"
+
+// Значения по умолчанию
+const (
+	DefaultTolerance     = 1e-6
+	DefaultMaxIterations = 50
+	// Наибольшее число итераций: каждая итерация - отдельное выражение
+	MaxIterations = 1000
+)
+
+// Параметры поиска корня f(x) = 0
+type Params struct {
+	Method string  `json:"method"`
+	A      float64 `json:"a"`
+	B      float64 `json:"b"`
+	// Поиск останавливается, когда шаг по x (для деления пополам - половина отрезка) не больше tolerance
+	Tolerance     float64 `json:"tolerance"`
+	MaxIterations int     `json:"maxIterations"`
+}
+
+// Проверяет параметры и подставляет значения по умолчанию
+func (p *Params) Validate() error {
+	if p.Method == "" {
+		p.Method = MethodBisection
+	}
+	if p.Tolerance == 0 {
+		p.Tolerance = DefaultTolerance
+	}
+	if p.MaxIterations == 0 {
+		p.MaxIterations = DefaultMaxIterations
+	}
+	switch {
+	case p.Method != MethodBisection && p.Method != MethodSecant:
+		return fmt.Errorf("unknown method %q", p.Method)
+	case math.IsNaN(p.A) || math.IsNaN(p.B) || math.IsInf(p.A, 0) || math.IsInf(p.B, 0):
+		return errors.New("a and b must be finite numbers")
+	case p.Method == MethodBisection && p.A >= p.B:
+		return errors.New("a must be less than b")
+	case p.A == p.B:
+		return errors.New("a and b must be different")
+	case p.Tolerance < 0 || math.IsNaN(p.Tolerance):
+		return errors.New("tolerance must be positive")
+	case p.MaxIterations < 0 || p.MaxIterations > MaxIterations:
+		return fmt.Errorf("maxIterations must be from 1 to %d", MaxIterations)
+	}
+	return nil
+}
+
+// Точка, в которой вычисляется f
+type Point struct {
+	// Номер итерации, на которой точка получена (0 - концы отрезка)
+	Iteration int     `json:"iteration"`
+	X         float64 `json:"x"`
+	// Значение f(x), null - ещё вычисляется
+	Fx *float64 `json:"fx"`
+	// Выражение, которым вычисляется f(x)
+	ExpressionID string `json:"expressionid"`
+}
+
+// Ход поиска корня
+type Progress struct {
+	Iterations int     `json:"iterations"`
+	Points     []Point `json:"points"`
+	// Номера точек в Points: концы текущего отрезка (bisection) или два последних приближения (secant)
+	Lo int `json:"lo"`
+	Hi int `json:"hi"`
+	// Найденный корень и значение в нём. Converged - false, если поиск остановлен по числу итераций.
+	Root      *float64 `json:"root"`
+	FRoot     *float64 `json:"fRoot"`
+	Converged bool     `json:"converged"`
+}
+
+// Поиск завершён
+func (p *Progress) Done() bool {
+	return p.Root != nil
+}
+
+// Есть точки, значения в которых ещё не получены
+func (p *Progress) Pending() bool {
+	for _, point := range p.Points {
+		if point.Fx == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Делает следующий шаг поиска, когда все значения f получены. Возвращает точки, в которых нужно вычислить f;
+// их нужно добавить в Points вызовом Add. Если точек нет, поиск завершён (Done).
+// Ошибка означает, что поиск продолжить нельзя.
+func (p *Progress) Next(params Params) ([]float64, error) {
+	if len(p.Points) == 0 {
+		return []float64{params.A, params.B}, nil
+	}
+	if p.Iterations == 0 && p.Lo == p.Hi {
+		return p.start(params)
+	}
+	last := len(p.Points) - 1
+	point := p.Points[last]
+	p.Iterations = point.Iteration
+	if params.Method == MethodBisection {
+		if sign(*p.Points[p.Lo].Fx) == sign(*point.Fx) {
+			p.Lo = last
+		} else {
+			p.Hi = last
+		}
+	} else {
+		p.Lo, p.Hi = p.Hi, last
+	}
+	lo, hi := p.Points[p.Lo], p.Points[p.Hi]
+	step := math.Abs(hi.X - lo.X)
+	if params.Method == MethodBisection {
+		step /= 2
+	}
+	switch {
+	case *point.Fx == 0 || step <= params.Tolerance:
+		p.finish(point, true)
+		return nil, nil
+	case p.Iterations >= params.MaxIterations:
+		p.finish(point, false)
+		return nil, nil
+	}
+	return p.step(params)
+}
+
+// Проверяет значения на концах отрезка
+func (p *Progress) start(params Params) ([]float64, error) {
+	a, b := p.Points[0], p.Points[1]
+	switch {
+	case *a.Fx == 0:
+		p.finish(a, true)
+		return nil, nil
+	case *b.Fx == 0:
+		p.finish(b, true)
+		return nil, nil
+	case params.Method == MethodBisection && sign(*a.Fx) == sign(*b.Fx):
+		return nil, fmt.Errorf("f(a) = %g and f(b) = %g have the same sign", *a.Fx, *b.Fx)
+	}
+	p.Lo, p.Hi = 0, 1
+	return p.step(params)
+}
+
+// Следующее приближение: середина отрезка или пересечение секущей с осью x
+func (p *Progress) step(params Params) ([]float64, error) {
+	lo, hi := p.Points[p.Lo], p.Points[p.Hi]
+	if params.Method == MethodBisection {
+		return []float64{lo.X + (hi.X-lo.X)/2}, nil
+	}
+	if *hi.Fx == *lo.Fx {
+		return nil, fmt.Errorf("secant step is undefined: f(%g) = f(%g) = %g", lo.X, hi.X, *hi.Fx)
+	}
+	x := hi.X - *hi.Fx*(hi.X-lo.X)/(*hi.Fx-*lo.Fx)
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return nil, fmt.Errorf("secant step diverged at x = %g", hi.X)
+	}
+	return []float64{x}, nil
+}
+
+// Добавляет точки, в которых f вычисляется выражениями expressionIDs
+func (p *Progress) Add(xs []float64, expressionIDs []string) {
+	iteration := 0
+	if len(p.Points) > 0 {
+		iteration = p.Iterations + 1
+	}
+	for i, x := range xs {
+		p.Points = append(p.Points, Point{Iteration: iteration, X: x, ExpressionID: expressionIDs[i]})
+	}
+}
+
+func (p *Progress) finish(point Point, converged bool) {
+	root, fRoot := point.X, *point.Fx
+	p.Root, p.FRoot, p.Converged = &root, &fRoot, converged
+}
+
+func sign(v float64) int {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
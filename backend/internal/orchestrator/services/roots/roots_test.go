@@ -0,0 +1,140 @@
+package roots
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Выполняет поиск так же, как оркестратор: вычисляет f в точках, которые возвращает Next, пока поиск не завершится
+func solve(t *testing.T, params Params, f func(x float64) float64) (Progress, error) {
+	t.Helper()
+	if err := params.Validate(); err != nil {
+		t.Fatalf("%+v: %v", params, err)
+	}
+	progress := Progress{}
+	for i := 0; i <= MaxIterations+1; i++ {
+		xs, err := progress.Next(params)
+		if err != nil || progress.Done() {
+			return progress, err
+		}
+		if len(xs) == 0 {
+			t.Fatalf("%+v: no points to evaluate and the search is not done", params)
+		}
+		ids := make([]string, len(xs))
+		for j := range xs {
+			ids[j] = fmt.Sprint(len(progress.Points) + j)
+		}
+		progress.Add(xs, ids)
+		if !progress.Pending() {
+			t.Fatalf("%+v: added points are not pending", params)
+		}
+		for j := range progress.Points {
+			if progress.Points[j].Fx == nil {
+				fx := f(progress.Points[j].X)
+				progress.Points[j].Fx = &fx
+			}
+		}
+	}
+	t.Fatalf("%+v: the search did not stop", params)
+	return progress, nil
+}
+
+func TestSearchConverges(t *testing.T) {
+	square := func(x float64) float64 { return x*x - 2 }
+	tests := []struct {
+		params Params
+		f      func(x float64) float64
+		root   float64
+	}{
+		{Params{Method: MethodBisection, A: 0, B: 2}, square, math.Sqrt2},
+		{Params{Method: MethodBisection, A: -2, B: 0, Tolerance: 1e-9}, square, -math.Sqrt2},
+		{Params{Method: MethodSecant, A: 1, B: 2}, square, math.Sqrt2},
+		// Приближения секущих выходят за отрезок
+		{Params{Method: MethodSecant, A: 3, B: 4}, square, math.Sqrt2},
+		{Params{Method: MethodBisection, A: 0, B: 3}, func(x float64) float64 { return x*x*x - x - 1 }, 1.324717957244746},
+	}
+	for _, test := range tests {
+		progress, err := solve(t, test.params, test.f)
+		if err != nil {
+			t.Errorf("%+v: unexpected error %v", test.params, err)
+			continue
+		}
+		if !progress.Converged {
+			t.Errorf("%+v: stopped after %d iterations without convergence", test.params, progress.Iterations)
+		}
+		tolerance := test.params.Tolerance
+		if tolerance == 0 {
+			tolerance = DefaultTolerance
+		}
+		if math.Abs(*progress.Root-test.root) > tolerance {
+			t.Errorf("%+v: root %v, want %v", test.params, *progress.Root, test.root)
+		}
+		if *progress.FRoot != test.f(*progress.Root) {
+			t.Errorf("%+v: f(root) = %v, want %v", test.params, *progress.FRoot, test.f(*progress.Root))
+		}
+	}
+}
+
+func TestSearchRootAtEnd(t *testing.T) {
+	for _, method := range []string{MethodBisection, MethodSecant} {
+		for _, params := range []Params{{Method: method, A: 2, B: 5}, {Method: method, A: -1, B: 2}} {
+			progress, err := solve(t, params, func(x float64) float64 { return x*x - 4 })
+			if err != nil {
+				t.Errorf("%+v: unexpected error %v", params, err)
+				continue
+			}
+			if *progress.Root != 2 || *progress.FRoot != 0 || !progress.Converged {
+				t.Errorf("%+v: root %v, f(root) %v, converged %v, want the end of the interval 2", params, *progress.Root, *progress.FRoot, progress.Converged)
+			}
+			if len(progress.Points) != 2 {
+				t.Errorf("%+v: %d points evaluated, want only the ends of the interval", params, len(progress.Points))
+			}
+		}
+	}
+}
+
+func TestSearchFails(t *testing.T) {
+	tests := []struct {
+		params Params
+		f      func(x float64) float64
+	}{
+		// Нет смены знака на отрезке
+		{Params{Method: MethodBisection, A: 2, B: 3}, func(x float64) float64 { return x*x - 2 }},
+		{Params{Method: MethodBisection, A: -1, B: 1}, func(x float64) float64 { return x*x + 1 }},
+		// Секущая параллельна оси x
+		{Params{Method: MethodSecant, A: -1, B: 1}, func(x float64) float64 { return x*x - 2 }},
+		{Params{Method: MethodSecant, A: 0, B: 1}, func(x float64) float64 { return 3 }},
+	}
+	for _, test := range tests {
+		progress, err := solve(t, test.params, test.f)
+		if err == nil {
+			t.Errorf("%+v: expected an error, got root %v", test.params, progress.Root)
+			continue
+		}
+		if progress.Done() {
+			t.Errorf("%+v: the search is done after error %v", test.params, err)
+		}
+	}
+}
+
+func TestSearchMaxIterations(t *testing.T) {
+	params := Params{Method: MethodBisection, A: 0, B: 2, Tolerance: 1e-12, MaxIterations: 5}
+	progress, err := solve(t, params, func(x float64) float64 { return x*x - 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Converged {
+		t.Errorf("converged after %d iterations, want a stop by maxIterations", progress.Iterations)
+	}
+	if progress.Iterations != 5 || len(progress.Points) != 7 {
+		t.Errorf("%d iterations and %d points, want 5 iterations and 7 points", progress.Iterations, len(progress.Points))
+	}
+	last := progress.Points[len(progress.Points)-1]
+	if *progress.Root != last.X || *progress.FRoot != *last.Fx {
+		t.Errorf("root %v, want the last approximation %v", *progress.Root, last.X)
+	}
+	if math.Abs(*progress.Root-math.Sqrt2) > 2.0/(1<<5) {
+		t.Errorf("root %v is farther from sqrt(2) than the last half of the interval", *progress.Root)
+	}
+}
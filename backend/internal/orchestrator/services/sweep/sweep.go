@@ -0,0 +1,118 @@
+package sweep
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const (
+	// Наибольшее число точек перебора: каждая точка - отдельное выражение
+	MaxPoints = 10000
+	// Сколько выражений перебора вычисляется одновременно, остальные точки ждут
+	MaxInFlight = 100
+)
+
+// Состояния точки перебора
+const (
+	// Выражение точки ещё не создано
+	PointWaiting = "waiting"
+	// Выражение создано и вычисляется
+	PointCalculating = "calculating"
+	PointSucceeded   = "succeeded"
+	PointFailed      = "failed"
+)
+
+// Диапазон значений переменной: from, from + step, ... до to включительно
+type Params struct {
+	From float64 `json:"from"`
+	To   float64 `json:"to"`
+	Step float64 `json:"step"`
+}
+
+func (p Params) Validate() error {
+	switch {
+	case math.IsNaN(p.From) || math.IsNaN(p.To) || math.IsInf(p.From, 0) || math.IsInf(p.To, 0):
+		return errors.New("from and to must be finite numbers")
+	case p.From > p.To:
+		return errors.New("from must not be greater than to")
+	case !(p.Step > 0) || math.IsInf(p.Step, 0):
+		return errors.New("step must be positive")
+	case p.count() > MaxPoints:
+		return fmt.Errorf("the range has more than %d points", MaxPoints)
+	}
+	return nil
+}
+
+// Число точек диапазона. Последняя точка, отстоящая от to меньше чем на миллионную долю шага, считается равной to.
+func (p Params) count() float64 {
+	return math.Floor((p.To-p.From)/p.Step+1e-6) + 1
+}
+
+// Значения переменной в порядке возрастания. Каждое значение считается от from, чтобы не накапливать ошибку
+// округления, и округляется до 15 значащих цифр: 3 * 0.1 - 0.3, а не 0.30000000000000004.
+func (p Params) Values() []float64 {
+	n := int(p.count())
+	res := make([]float64, n)
+	for i := range res {
+		res[i], _ = strconv.ParseFloat(strconv.FormatFloat(p.From+float64(i)*p.Step, 'g', 15, 64), 64)
+	}
+	return res
+}
+
+// Строка таблицы результатов
+type Point struct {
+	X     float64  `json:"x"`
+	Value *float64 `json:"value"`
+	State string   `json:"state"`
+	// Причина, по которой значение не вычислено
+	Error *string `json:"error,omitempty"`
+	// Выражение, которым вычисляется значение (пусто, пока оно не создано)
+	ExpressionID string `json:"expressionid,omitempty"`
+}
+
+// Ход перебора: все точки диапазона в порядке возрастания
+type Progress struct {
+	Points []Point `json:"points"`
+}
+
+// Создаёт точки диапазона, если они ещё не созданы
+func (p *Progress) Init(params Params) {
+	if p.Points != nil {
+		return
+	}
+	values := params.Values()
+	p.Points = make([]Point, len(values))
+	for i, x := range values {
+		p.Points[i] = Point{X: x, State: PointWaiting}
+	}
+}
+
+// Число точек в состоянии state
+func (p *Progress) Count(state string) int {
+	res := 0
+	for _, point := range p.Points {
+		if point.State == state {
+			res++
+		}
+	}
+	return res
+}
+
+// Номера точек, выражения которых можно создать, не превышая MaxInFlight одновременно вычисляемых
+func (p *Progress) Schedule() []int {
+	free := MaxInFlight - p.Count(PointCalculating)
+	res := []int{}
+	for i := 0; i < len(p.Points) && len(res) < free; i++ {
+		if p.Points[i].State == PointWaiting {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// Перебор завершён: все значения вычислены или завершились ошибкой
+func (p *Progress) Done() bool {
+	return p.Count(PointSucceeded)+p.Count(PointFailed) == len(p.Points)
+}
@@ -0,0 +1,92 @@
+package sweep
+
+import "testing"
+
+func TestValues(t *testing.T) {
+	tests := []struct {
+		params Params
+		values []float64
+	}{
+		{Params{From: 0, To: 1, Step: 0.1}, []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1}},
+		{Params{From: 0, To: 0.3, Step: 0.1}, []float64{0, 0.1, 0.2, 0.3}},
+		{Params{From: -1, To: 1, Step: 0.5}, []float64{-1, -0.5, 0, 0.5, 1}},
+		// to не попадает в диапазон: последняя точка меньше to
+		{Params{From: 0, To: 1, Step: 0.3}, []float64{0, 0.3, 0.6, 0.9}},
+		{Params{From: 2, To: 2, Step: 1}, []float64{2}},
+	}
+	for _, test := range tests {
+		if err := test.params.Validate(); err != nil {
+			t.Errorf("%+v: unexpected error %v", test.params, err)
+			continue
+		}
+		values := test.params.Values()
+		if len(values) != len(test.values) {
+			t.Errorf("%+v: values %v, want %v", test.params, values, test.values)
+			continue
+		}
+		for i := range values {
+			if values[i] != test.values[i] {
+				t.Errorf("%+v: values %v, want %v", test.params, values, test.values)
+				break
+			}
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		params Params
+		valid  bool
+	}{
+		{Params{From: 0, To: MaxPoints - 1, Step: 1}, true},
+		{Params{From: 0, To: MaxPoints, Step: 1}, false},
+		{Params{From: 0, To: 1, Step: 1e-6}, false},
+		{Params{From: 0, To: 1e300, Step: 1}, false},
+		{Params{From: 1, To: 0, Step: 0.1}, false},
+		{Params{From: 0, To: 1, Step: 0}, false},
+		{Params{From: 0, To: 1, Step: -0.1}, false},
+	}
+	for _, test := range tests {
+		err := test.params.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: error %v, want valid %v", test.params, err, test.valid)
+		}
+	}
+}
+
+func TestScheduleInFlight(t *testing.T) {
+	params := Params{From: 0, To: 2*MaxInFlight + 49, Step: 1}
+	progress := Progress{}
+	progress.Init(params)
+	for step := 0; !progress.Done(); step++ {
+		if step > len(progress.Points) {
+			t.Fatal("the sweep did not finish")
+		}
+		for _, index := range progress.Schedule() {
+			if progress.Points[index].State != PointWaiting {
+				t.Fatalf("point %d in state %s is scheduled again", index, progress.Points[index].State)
+			}
+			progress.Points[index].State = PointCalculating
+		}
+		if n := progress.Count(PointCalculating); n > MaxInFlight {
+			t.Fatalf("%d points are calculating, want at most %d", n, MaxInFlight)
+		}
+		// Завершается часть вычисляемых точек, одна из них - ошибкой
+		finished := 0
+		for i := range progress.Points {
+			if progress.Points[i].State == PointCalculating && finished < 30 {
+				progress.Points[i].State = PointSucceeded
+				if finished == 0 {
+					progress.Points[i].State = PointFailed
+				}
+				finished++
+			}
+		}
+	}
+	if got := progress.Count(PointWaiting) + progress.Count(PointCalculating); got != 0 {
+		t.Errorf("%d points are not finished", got)
+	}
+	if len(progress.Points) != 2*MaxInFlight+50 {
+		t.Errorf("%d points, want %d", len(progress.Points), 2*MaxInFlight+50)
+	}
+}
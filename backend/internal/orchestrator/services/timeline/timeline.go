@@ -0,0 +1,204 @@
+package timeline
+
+import (
+	"sort"
+	"time"
+
+	"github.com/klef99/distributed-calculation-backend/pkg/database"
+)
+
+// Выполнение одной операции
+type Operation struct {
+	OperationID string     `json:"operationid"`
+	Operator    string     `json:"operator"`
+	Worker      *string    `json:"worker"`
+	QueuedAt    *time.Time `json:"queuedAt"`
+	StartedAt   *time.Time `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt"`
+	// Ожидание в очереди (от отправки до начала вычисления) и время вычисления, секунды
+	WaitSeconds *float64 `json:"waitSeconds"`
+	RunSeconds  *float64 `json:"runSeconds"`
+	// Операция лежит на критическом пути
+	Critical bool `json:"critical"`
+}
+
+// Загрузка агента за время вычисления выражения
+type Worker struct {
+	WorkerName string `json:"workerName"`
+	Operations int    `json:"operations"`
+	// Время, когда агент вычислял хотя бы одну операцию выражения
+	BusySeconds float64 `json:"busySeconds"`
+	IdleSeconds float64 `json:"idleSeconds"`
+	// Доля занятого времени (0..1)
+	Utilization float64 `json:"utilization"`
+}
+
+// Фактический ход вычисления выражения
+type Timeline struct {
+	// От первой отправки операции агентам до получения последнего результата
+	StartedAt       *time.Time `json:"startedAt"`
+	FinishedAt      *time.Time `json:"finishedAt"`
+	MakespanSeconds float64    `json:"makespanSeconds"`
+	// Суммарное время вычисления всех операций
+	WorkSeconds float64 `json:"workSeconds"`
+	// Среднее и наибольшее число одновременно вычислявшихся операций
+	AverageParallelism float64 `json:"averageParallelism"`
+	MaxParallelism     int     `json:"maxParallelism"`
+	// Цепочка операций, результат каждой из которых пришёл последним среди операндов следующей.
+	// Начинается с первой операции, заканчивается последней вычисленной.
+	CriticalPath []string `json:"criticalPath"`
+	// Время вычисления операций критического пути; остальное время пути - ожидание в очереди и опрос базы
+	CriticalPathWorkSeconds float64     `json:"criticalPathWorkSeconds"`
+	Operations              []Operation `json:"operations"`
+	Workers                 []Worker    `json:"workers"`
+}
+
+type interval struct {
+	start, finish time.Time
+}
+
+// Строит временную шкалу по операциям выражения. workers - имена известных агентов:
+// агенты, не вычислявшие ни одной операции, попадают в отчёт как простаивавшие.
+func Build(ops []database.ExpressionOperation, workers []string) Timeline {
+	res := Timeline{CriticalPath: []string{}, Operations: make([]Operation, 0, len(ops)), Workers: []Worker{}}
+	byWorker := map[string][]interval{}
+	for _, name := range workers {
+		byWorker[name] = nil
+	}
+	runs := []interval{}
+	var last *database.ExpressionOperation
+	for i, op := range ops {
+		item := Operation{OperationID: op.OperationID, Operator: op.Operator, Worker: op.Worker, QueuedAt: op.QueuedTime, StartedAt: op.StartedTime, FinishedAt: op.FinishedTime}
+		if op.QueuedTime != nil && op.StartedTime != nil {
+			wait := max(op.StartedTime.Sub(*op.QueuedTime).Seconds(), 0)
+			item.WaitSeconds = &wait
+		}
+		start := op.QueuedTime
+		if start == nil {
+			start = op.StartedTime
+		}
+		if start != nil && (res.StartedAt == nil || start.Before(*res.StartedAt)) {
+			res.StartedAt = start
+		}
+		if op.StartedTime != nil && op.FinishedTime != nil {
+			run := op.FinishedTime.Sub(*op.StartedTime).Seconds()
+			item.RunSeconds = &run
+			res.WorkSeconds += run
+			runs = append(runs, interval{*op.StartedTime, *op.FinishedTime})
+			if op.Worker != nil {
+				byWorker[*op.Worker] = append(byWorker[*op.Worker], interval{*op.StartedTime, *op.FinishedTime})
+			}
+			if last == nil || op.FinishedTime.After(*last.FinishedTime) {
+				last = &ops[i]
+				res.FinishedAt = op.FinishedTime
+			}
+		}
+		res.Operations = append(res.Operations, item)
+	}
+	if res.StartedAt != nil && res.FinishedAt != nil {
+		res.MakespanSeconds = max(res.FinishedAt.Sub(*res.StartedAt).Seconds(), 0)
+	}
+	if res.MakespanSeconds > 0 {
+		res.AverageParallelism = res.WorkSeconds / res.MakespanSeconds
+	}
+	res.MaxParallelism = maxOverlap(runs)
+	if last != nil {
+		res.CriticalPath, res.CriticalPathWorkSeconds = criticalPath(ops, *last)
+	}
+	critical := make(map[string]bool, len(res.CriticalPath))
+	for _, id := range res.CriticalPath {
+		critical[id] = true
+	}
+	for i := range res.Operations {
+		res.Operations[i].Critical = critical[res.Operations[i].OperationID]
+	}
+	for name, intervals := range byWorker {
+		busy := union(intervals).Seconds()
+		worker := Worker{WorkerName: name, Operations: len(intervals), BusySeconds: busy, IdleSeconds: max(res.MakespanSeconds-busy, 0)}
+		if res.MakespanSeconds > 0 {
+			worker.Utilization = min(busy/res.MakespanSeconds, 1)
+		}
+		res.Workers = append(res.Workers, worker)
+	}
+	sort.Slice(res.Workers, func(i, j int) bool { return res.Workers[i].WorkerName < res.Workers[j].WorkerName })
+	return res
+}
+
+// Путь от последней вычисленной операции вниз: на каждом шаге выбирается операнд, вычисленный позже остальных
+func criticalPath(ops []database.ExpressionOperation, last database.ExpressionOperation) ([]string, float64) {
+	children := map[string][]database.ExpressionOperation{}
+	for _, op := range ops {
+		if op.FinishedTime == nil || op.StartedTime == nil {
+			continue
+		}
+		for _, parent := range op.Parents {
+			children[parent.OperationID] = append(children[parent.OperationID], op)
+		}
+	}
+	path := []string{}
+	work := 0.0
+	for cur, ok := last, true; ok; {
+		path = append(path, cur.OperationID)
+		work += cur.FinishedTime.Sub(*cur.StartedTime).Seconds()
+		next, found := database.ExpressionOperation{}, false
+		for _, child := range children[cur.OperationID] {
+			if !found || child.FinishedTime.After(*next.FinishedTime) {
+				next, found = child, true
+			}
+		}
+		cur, ok = next, found
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, work
+}
+
+// Наибольшее число пересекающихся интервалов
+func maxOverlap(intervals []interval) int {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	events := make([]event, 0, 2*len(intervals))
+	for _, iv := range intervals {
+		events = append(events, event{iv.start, 1}, event{iv.finish, -1})
+	}
+	// При совпадении времени окончание раньше начала
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+	res, cur := 0, 0
+	for _, e := range events {
+		cur += e.delta
+		res = max(res, cur)
+	}
+	return res
+}
+
+// Общая длина объединения интервалов
+func union(intervals []interval) time.Duration {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+	var res time.Duration
+	var cur *interval
+	for i := range intervals {
+		iv := intervals[i]
+		if cur != nil && !iv.start.After(cur.finish) {
+			if iv.finish.After(cur.finish) {
+				cur.finish = iv.finish
+			}
+			continue
+		}
+		if cur != nil {
+			res += cur.finish.Sub(cur.start)
+		}
+		cur = &iv
+	}
+	if cur != nil {
+		res += cur.finish.Sub(cur.start)
+	}
+	return res
+}
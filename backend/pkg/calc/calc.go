@@ -2,11 +2,10 @@ package calc
 
 import (
 	"fmt"
-	"regexp"
-	"strconv"
+	"math"
+	"math/big"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/google/uuid"
 )
@@ -14,93 +13,90 @@ import (
 type Operation struct {
 	ExpressionID string
 	Operator     string
-	V1           interface{}
-	V2           interface{}
-	OperationID  string
-	ParentID     string
-	Left         bool
-	Status       int
-	Result       interface{}
+	// Операнды по порядку; nil - результат дочерней операции ещё не получен
+	Args        []interface{}
+	OperationID string
+	// Операции, которым передаётся результат. Одинаковые поддеревья выражения вычисляются один раз,
+	// поэтому у операции может быть несколько родителей. У корневой операции один родитель - само выражение.
+	Parents []Parent
+	// Условная операция, выбор ветви которой запускает эту операцию ("" - операция выполняется сразу),
+	// и номер операнда условной операции, к ветви которого относится операция (1 - then, 2 - else)
+	Guard  string
+	Branch int
+	Status int
+	Result interface{}
+	// Режим вычисления (ModeFloat или ModeExact). В точном режиме операнды и результат - строки-дроби.
+	Mode string
 }
 
-func (op Operation) Task(operTimeouts map[string]time.Duration) float64 {
-	time.Sleep(operTimeouts[op.Operator])
-	switch op.Operator {
-	case "+":
-		return op.V1.(float64) + op.V2.(float64)
-	case "-":
-		return op.V1.(float64) - op.V2.(float64)
-	case "/":
-		return op.V1.(float64) / op.V2.(float64)
-	case "*":
-		return op.V1.(float64) * op.V2.(float64)
-	}
-	panic("unreachable operator")
-}
-
-func precedence(operator rune) int {
-	switch operator {
-	case '+', '-':
-		return 1
-	case '*', '/':
-		return 2
-	}
-	return 0
-}
-
-func isDigit(char rune) bool {
-	return unicode.IsDigit(char)
-}
-
-func infixToPostfix(infix string) string {
-	var postfix strings.Builder
-	var stack []rune
-	var number strings.Builder
-	infix = strings.TrimSpace(infix)
-
-	for _, char := range infix {
-		if isDigit(char) {
-			number.WriteRune(char)
-		} else {
-			if number.Len() > 0 {
-				postfix.WriteString(number.String())
-				postfix.WriteRune(' ')
-				number.Reset()
-			}
+// Ребро от операции к родителю
+type Parent struct {
+	// UUID родительской операции (для корневой операции - UUID выражения)
+	OperationID string
+	// Номер операнда родительской операции, в который попадёт результат
+	Position int
+}
 
-			switch char {
-			case '(':
-				stack = append(stack, char)
-			case ')':
-				for len(stack) > 0 && stack[len(stack)-1] != '(' {
-					postfix.WriteRune(stack[len(stack)-1])
-					postfix.WriteRune(' ')
-					stack = stack[:len(stack)-1]
-				}
-				stack = stack[:len(stack)-1]
-			default:
-				for len(stack) > 0 && precedence(stack[len(stack)-1]) >= precedence(char) {
-					postfix.WriteRune(stack[len(stack)-1])
-					postfix.WriteRune(' ')
-					stack = stack[:len(stack)-1]
-				}
-				stack = append(stack, char)
-			}
+// Параметры разбиения выражения на операции
+type Options struct {
+	Variables map[string]float64
+	Mode      string
+	// Перестроить цепочки + и * в сбалансированные деревья (в точном режиме выполняется всегда)
+	Balance bool
+	// Формат записи выражения (FormatInfix, FormatRPN, FormatPrefix или FormatLaTeX), по умолчанию инфиксный
+	Format string
+}
+
+// Выполняет операцию. Результат - float64, в точном режиме - строка-дробь.
+// Деление на ноль, переполнение, NaN и неизвестный оператор возвращаются как ошибка.
+func (op Operation) Task(operTimeouts map[string]time.Duration) (interface{}, error) {
+	time.Sleep(operTimeouts[op.Operator])
+	if op.Mode == ModeExact {
+		return op.exactTask()
+	}
+	args := make([]float64, len(op.Args))
+	for i, arg := range op.Args {
+		v, ok := arg.(float64)
+		if !ok {
+			return nil, operationError(CodeMalformedOperand, "malformed operand %v", arg)
 		}
+		args[i] = v
 	}
-
-	if number.Len() > 0 {
-		postfix.WriteString(number.String())
-		postfix.WriteRune(' ')
+	res, err := op.eval(args)
+	if err != nil {
+		return nil, err
 	}
-
-	for len(stack) > 0 {
-		postfix.WriteRune(stack[len(stack)-1])
-		postfix.WriteRune(' ')
-		stack = stack[:len(stack)-1]
+	switch {
+	case math.IsNaN(res):
+		return nil, operationError(CodeNotANumber, "result of %q is not a number", op.Operator)
+	case math.IsInf(res, 0):
+		return nil, operationError(CodeOverflow, "overflow in %q", op.Operator)
 	}
+	return res, nil
+}
 
-	return strings.TrimSpace(postfix.String())
+func (op Operation) eval(args []float64) (float64, error) {
+	// Ноль в отрицательной степени - деление на ноль, как и в точном режиме, а не переполнение
+	if (op.Operator == "^" || op.Operator == "pow") && len(args) == 2 && args[0] == 0 && args[1] < 0 {
+		return 0, operationError(CodeDivisionByZero, "division by zero")
+	}
+	if f, ok := functions[op.Operator]; ok {
+		return f.eval(args), nil
+	}
+	switch len(args) {
+	case 1:
+		if f, ok := unaryOperations[op.Operator]; ok {
+			return f(args[0]), nil
+		}
+	case 2:
+		if f, ok := binaryOperations[op.Operator]; ok {
+			if divisionOperators[op.Operator] && args[1] == 0 {
+				return 0, operationError(CodeDivisionByZero, "division by zero")
+			}
+			return f(args[0], args[1]), nil
+		}
+	}
+	return 0, operationError(CodeUnknownOperator, "unknown operator %q", op.Operator)
 }
 
 func IsOperation(t interface{}) bool {
@@ -111,80 +107,211 @@ func IsOperation(t interface{}) bool {
 	return false
 }
 
-func TransformExpressionToStack(expressionID, expression string) ([]Operation, error) {
-	tokens := strings.Split(infixToPostfix(expression), " ")
-	opers := make([]interface{}, 0)
-	tasks := make([]Operation, 0)
-	for i := 0; i < len(tokens); i++ {
-		if v, err := strconv.ParseFloat(tokens[i], 64); err == nil {
-			opers = append(opers, v)
-		} else {
-			if len(opers) < 2 {
-				return []Operation{}, fmt.Errorf("don't much values. Unary operations are not supported")
-			}
-			v1 := opers[len(opers)-2]
-			v2 := opers[len(opers)-1]
-			opers = opers[:len(opers)-2]
-			task := Operation{Operator: tokens[i], OperationID: uuid.New().String(), ExpressionID: expressionID, ParentID: expressionID, Status: 0}
-			if IsOperation(v1) {
-				v1, _ := v1.(Operation)
-				v1.ParentID = task.OperationID
-				v1.Left = true
-				for i := 0; i < len(tasks); i++ {
-					if v1.OperationID == tasks[i].OperationID {
-						tasks[i] = v1
-						break
-					}
-				}
+// Ссылка на уже созданную операцию (индекс в planner.tasks)
+type operationRef int
+
+type planner struct {
+	expressionID string
+	variables    map[string]float64
+	mode         string
+	tasks        []Operation
+	// Ветвь условной операции, операции которой сейчас создаются
+	guard  string
+	branch int
+	// Уже созданные операции по записи "оператор(операнды)", чтобы одинаковые поддеревья вычислялись один раз
+	known map[string]operationRef
+}
+
+// Добавляет операции для поддерева node. Возвращает значение, если поддерево вычисляется сразу
+// (float64, в точном режиме *big.Rat), иначе operationRef.
+func (p *planner) add(node *Node) (interface{}, error) {
+	switch node.Kind {
+	case NumberNode:
+		if p.mode == ModeExact {
+			return exactLiteral(node.Text)
+		}
+		return node.Value, nil
+	case VariableNode:
+		v, ok := p.variables[node.Text]
+		if !ok {
+			return nil, fmt.Errorf("unbound variable %q", node.Text)
+		}
+		if p.mode == ModeExact {
+			return exactFromFloat(v), nil
+		}
+		return v, nil
+	}
+	if node.Kind == FunctionNode && node.Operator == ConditionalOperator {
+		return p.addConditional(node)
+	}
+	args := make([]interface{}, len(node.Args))
+	for i, arg := range node.Args {
+		v, err := p.add(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if node.Kind == OperatorNode && len(args) == 1 && (node.Operator == "+" || node.Operator == "-") {
+		switch v := args[0].(type) {
+		case float64:
+			// Знак числа учитывается сразу, без отдельной операции
+			if node.Operator == "-" {
+				return -v, nil
 			}
-			if IsOperation(v2) {
-				v2, _ := v2.(Operation)
-				v2.ParentID = task.OperationID
-				v2.Left = false
-				for i := 0; i < len(tasks); i++ {
-					if v2.OperationID == tasks[i].OperationID {
-						tasks[i] = v2
-						break
-					}
-				}
+			return v, nil
+		case *big.Rat:
+			if node.Operator == "-" {
+				return new(big.Rat).Neg(v), nil
 			}
-			task.V1 = v1
-			task.V2 = v2
-			if task.Operator == "/" && task.V2 == 0 {
-				return []Operation{}, fmt.Errorf("division by 0")
+			return v, nil
+		case operationRef:
+			// Унарный плюс не меняет значение
+			if node.Operator == "+" {
+				return v, nil
 			}
-			opers = append(opers, task)
-			tasks = append(tasks, task)
 		}
 	}
-	for i := 0; i < len(tasks); i++ {
-		if IsOperation(tasks[i].V1) {
-			tasks[i].V1 = nil
+	// В ветви условной операции деление на ноль - ошибка, только если ветвь будет выбрана
+	if node.Kind == OperatorNode && divisionOperators[node.Operator] && isZero(args[1]) && p.guard == "" {
+		return nil, operationError(CodeDivisionByZero, "division by 0")
+	}
+	// Одинаковые поддеревья объединяются только внутри одной ветви, иначе общая операция зависела бы от нескольких условий
+	key := fmt.Sprintf("%s/%d/%s", p.guard, p.branch, operationKey(node.Operator, args))
+	if ref, ok := p.known[key]; ok {
+		return ref, nil
+	}
+	ref := p.appendOperation(p.newOperation(node.Operator, len(args)), args)
+	p.known[key] = ref
+	return ref, nil
+}
+
+// Добавляет операцию task с операндами args: значения записываются в task.Args, дочерним операциям добавляется ребро к task
+func (p *planner) appendOperation(task Operation, args []interface{}) operationRef {
+	for i, arg := range args {
+		if ref, ok := arg.(operationRef); ok {
+			p.tasks[ref].Parents = append(p.tasks[ref].Parents, Parent{OperationID: task.OperationID, Position: i})
+			continue
+		}
+		task.Args[i] = operand(arg)
+	}
+	p.tasks = append(p.tasks, task)
+	return operationRef(len(p.tasks) - 1)
+}
+
+// Условная операция. Если условие известно сразу, операции создаются только для выбранной ветви.
+// Иначе операции обеих ветвей создаются спящими (Guard) и запускаются после вычисления условия.
+func (p *planner) addConditional(node *Node) (interface{}, error) {
+	cond, err := p.add(node.Args[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := cond.(operationRef); !ok {
+		if IsTrue(cond) {
+			return p.add(node.Args[1])
 		}
-		if IsOperation(tasks[i].V2) {
-			tasks[i].V2 = nil
+		return p.add(node.Args[2])
+	}
+	task := p.newOperation(ConditionalOperator, 3)
+	args := []interface{}{cond, nil, nil}
+	guard, branch := p.guard, p.branch
+	for i := 1; i <= 2; i++ {
+		p.guard, p.branch = task.OperationID, i
+		args[i], err = p.add(node.Args[i])
+		if err != nil {
+			return nil, err
 		}
 	}
-	return tasks, nil
+	p.guard, p.branch = guard, branch
+	return p.appendOperation(task, args), nil
 }
 
-// Очищение и валидация выражения
-func ValidExpression(expression string) (string, error) {
-	re := regexp.MustCompile(`[^0-9+\-*/() ]`)
-	res := re.ReplaceAllString(strings.ReplaceAll(expression, " ", ""), "")
-	scb := []rune{}
-	for i := 0; i < len(res); i++ {
-		if res[i] == '(' {
-			scb = append(scb, ')')
-		} else if res[i] == ')' {
-			if len(scb) == 0 {
-				return "", fmt.Errorf("invalid count of brackets")
-			}
-			scb = scb[:len(scb)-1]
+// Запись операции, по которой находятся одинаковые поддеревья: (3*4)+(3*4) даёт одну операцию 3*4
+func operationKey(operator string, args []interface{}) string {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if ref, ok := arg.(operationRef); ok {
+			parts[i] = fmt.Sprintf("#%d", ref)
+			continue
 		}
+		parts[i] = FormatValue(operand(arg))
 	}
-	if len(scb) != 0 {
-		return "", fmt.Errorf("invalid count of brackets")
+	return operator + "(" + strings.Join(parts, ",") + ")"
+}
+
+func (p *planner) newOperation(operator string, arity int) Operation {
+	return Operation{Operator: operator, Args: make([]interface{}, arity), OperationID: uuid.New().String(), ExpressionID: p.expressionID, Guard: p.guard, Branch: p.branch, Status: 0, Mode: p.mode}
+}
+
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case float64:
+		return v == 0
+	case *big.Rat:
+		return v.Sign() == 0
 	}
-	return res, nil
+	return false
+}
+
+// Значение операнда в том виде, в котором оно передаётся агенту
+func operand(v interface{}) interface{} {
+	if r, ok := v.(*big.Rat); ok {
+		return r.RatString()
+	}
+	return v
+}
+
+// Разбивает выражение на операции, подставляя значения переменных из opts.Variables
+func TransformExpressionToStack(expressionID, expression string, opts Options) ([]Operation, error) {
+	tree, err := ParseFormat(expression, opts.Format)
+	if err != nil {
+		return []Operation{}, err
+	}
+	mode := opts.Mode
+	if mode == "" {
+		mode = ModeFloat
+	}
+	if opts.Balance || mode == ModeExact {
+		tree = Balance(tree)
+	}
+	p := &planner{expressionID: expressionID, variables: opts.Variables, mode: mode, tasks: make([]Operation, 0), known: make(map[string]operationRef)}
+	root, err := p.add(tree)
+	if err != nil {
+		return []Operation{}, err
+	}
+	// Выражение из одного числа: корнем становится унарный плюс, чтобы результат прошёл обычный путь
+	if _, ok := root.(operationRef); !ok {
+		task := p.newOperation("+", 1)
+		task.Args[0] = operand(root)
+		p.tasks = append(p.tasks, task)
+		root = operationRef(len(p.tasks) - 1)
+	}
+	ref := root.(operationRef)
+	p.tasks[ref].Parents = []Parent{{OperationID: expressionID, Position: 0}}
+	return p.tasks, nil
+}
+
+// Валидация выражения. Возвращает его каноническую инфиксную запись (Canonical).
+// Ошибки разбора и переменные без значений возвращаются как *SyntaxError.
+func ValidExpression(expression string, opts Options) (string, error) {
+	if !IsFormat(opts.Format) {
+		return "", fmt.Errorf("unknown format %q", opts.Format)
+	}
+	tokens, err := tokenizeFormat(expression, opts.Format)
+	if err != nil {
+		return "", err
+	}
+	tree, err := parseFormatTokens(tokens, opts.Format)
+	if err != nil {
+		return "", err
+	}
+	if !IsMode(opts.Mode) {
+		return "", fmt.Errorf("unknown mode %q", opts.Mode)
+	}
+	err = checkBindings(tree, opts.Variables)
+	if err != nil {
+		return "", err
+	}
+	// Выражение любого формата сохраняется в канонической инфиксной записи
+	return Canonical(tree), nil
 }
@@ -0,0 +1,101 @@
+package calc
+
+import "testing"
+
+// Операции с оператором operator
+func operationsOf(tasks []Operation, operator string) []Operation {
+	res := []Operation{}
+	for _, task := range tasks {
+		if task.Operator == operator {
+			res = append(res, task)
+		}
+	}
+	return res
+}
+
+func TestSharedSubexpressionCount(t *testing.T) {
+	tests := []struct {
+		expression string
+		operations int
+	}{
+		{"(3*4)+(3*4)", 2},
+		{"(3*4)+(3*4)/(3*4)", 3},
+		{"(3*4)+(4*3)", 3},
+		{"(1+2)*(1+2)-(1+2)*(1+2)", 3},
+		{"max(3*4, 3*4, 3*4)", 2},
+		{"(2<3) ? 3*4 : 3*4", 4},
+		{"3*4 + ((2<3) ? 3*4 : 0)", 5},
+		{"(2<3) ? (3*4)+(3*4) : 1", 4},
+	}
+	for _, test := range tests {
+		tasks, err := TransformExpressionToStack("e", test.expression, Options{})
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		if len(tasks) != test.operations {
+			t.Errorf("%q: %d operations, want %d", test.expression, len(tasks), test.operations)
+		}
+	}
+}
+
+func TestSharedSubexpressionParents(t *testing.T) {
+	tasks, err := TransformExpressionToStack("e", "(3*4)+(3*4)/(3*4)", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := operationsOf(tasks, "*")
+	div := operationsOf(tasks, "/")
+	add := operationsOf(tasks, "+")
+	if len(shared) != 1 || len(div) != 1 || len(add) != 1 {
+		t.Fatalf("operations: %d *, %d /, %d +, want one of each", len(shared), len(div), len(add))
+	}
+	want := []Parent{
+		{OperationID: add[0].OperationID, Position: 0},
+		{OperationID: div[0].OperationID, Position: 0},
+		{OperationID: div[0].OperationID, Position: 1},
+	}
+	parents := map[Parent]bool{}
+	for _, parent := range shared[0].Parents {
+		parents[parent] = true
+	}
+	if len(shared[0].Parents) != len(want) {
+		t.Errorf("shared operation has %d parents, want %d: %v", len(shared[0].Parents), len(want), shared[0].Parents)
+	}
+	for _, parent := range want {
+		if !parents[parent] {
+			t.Errorf("shared operation has no parent %v", parent)
+		}
+	}
+	// Операнды общей операции не заполняются: оба приходят от одной дочерней операции
+	for i, arg := range div[0].Args {
+		if arg != nil {
+			t.Errorf("operand %d of / is %v, want the result of the shared operation", i, arg)
+		}
+	}
+}
+
+func TestSharedSubexpressionBranches(t *testing.T) {
+	tasks, err := TransformExpressionToStack("e", "(2<3) ? 3*4 : 3*4", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cond := operationsOf(tasks, ConditionalOperator)
+	mul := operationsOf(tasks, "*")
+	if len(cond) != 1 || len(mul) != 2 {
+		t.Fatalf("operations: %d conditional, %d *, want 1 and 2", len(cond), len(mul))
+	}
+	branches := map[int]bool{}
+	for _, task := range mul {
+		if task.Guard != cond[0].OperationID {
+			t.Errorf("branch operation is guarded by %q, want the conditional operation", task.Guard)
+		}
+		if len(task.Parents) != 1 || task.Parents[0].Position != task.Branch {
+			t.Errorf("branch %d operation has parents %v, want only operand %d of the conditional operation", task.Branch, task.Parents, task.Branch)
+		}
+		branches[task.Branch] = true
+	}
+	if !branches[1] || !branches[2] {
+		t.Errorf("branches of the operations: %v, want 1 and 2", branches)
+	}
+}
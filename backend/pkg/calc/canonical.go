@@ -0,0 +1,96 @@
+package calc
+
+import (
+	"strings"
+)
+
+// Каноническая инфиксная запись выражения: пробелы вокруг бинарных операторов, после запятых
+// и вокруг ? и :, только необходимые скобки, ^ вместо **, cond ? a : b вместо if(cond, a, b).
+// Разбор канонической записи даёт то же дерево, поэтому выражение разбивается на те же операции.
+func Canonical(node *Node) string {
+	switch node.Kind {
+	case NumberNode:
+		return canonicalNumber(node.Text)
+	case VariableNode:
+		return node.Text
+	case OperatorNode:
+		if len(node.Args) == 1 {
+			return node.Operator + canonicalOperand(node, node.Args[0], true)
+		}
+		return canonicalOperand(node, node.Args[0], false) + " " + node.Operator + " " + canonicalOperand(node, node.Args[1], true)
+	}
+	if node.Operator == ConditionalOperator {
+		// Условие в скобках, если оно само условное; ветви разбираются с наименьшим приоритетом
+		cond := Canonical(node.Args[0])
+		if canonicalPrecedence(node.Args[0]) == conditionalPrecedence {
+			cond = "(" + cond + ")"
+		}
+		return cond + " ? " + Canonical(node.Args[1]) + " : " + Canonical(node.Args[2])
+	}
+	args := make([]string, len(node.Args))
+	for i, arg := range node.Args {
+		args[i] = Canonical(arg)
+	}
+	return node.Operator + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Разбирает выражение формата format и возвращает его каноническую запись
+func CanonicalExpression(expression, format string) (string, error) {
+	tree, err := ParseFormat(expression, format)
+	if err != nil {
+		return "", err
+	}
+	return Canonical(tree), nil
+}
+
+// Приоритет узла в инфиксной записи (как у парсера), у чисел, переменных и функций - наивысший
+func canonicalPrecedence(node *Node) int {
+	switch node.Kind {
+	case OperatorNode:
+		if len(node.Args) == 1 {
+			return prefixOperators[node.Operator]
+		}
+		return binaryOperators[node.Operator].precedence
+	case FunctionNode:
+		if node.Operator == ConditionalOperator {
+			return conditionalPrecedence
+		}
+	}
+	return maxPrecedence
+}
+
+// Операнд оператора parent в скобках, если без них парсер построил бы другое дерево
+func canonicalOperand(parent, operand *Node, right bool) string {
+	res := Canonical(operand)
+	parentPrecedence, precedence := canonicalPrecedence(parent), canonicalPrecedence(operand)
+	parens := precedence < parentPrecedence
+	if precedence == parentPrecedence && len(parent.Args) == 2 {
+		// a - (b - c), (a ^ b) ^ c и сравнения, которые нельзя записывать цепочкой
+		parens = right != binaryOperators[parent.Operator].rightAssoc || comparisonOperators[parent.Operator]
+	}
+	// Унарный плюс сразу после оператора парсер считает опечаткой: 2 + (+a), 2 * (+a - b)
+	if right && strings.HasPrefix(res, "+") {
+		parens = true
+	}
+	if parens {
+		return "(" + res + ")"
+	}
+	return res
+}
+
+// Запись числа без изменения значения: .5 - 0.5, 2. - 2, 1E3 - 1e3, 0X1f - 0x1F
+func canonicalNumber(text string) string {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		return "0x" + strings.ToUpper(text[2:])
+	}
+	text = strings.ToLower(text)
+	if strings.HasPrefix(text, ".") {
+		text = "0" + text
+	}
+	mantissa, exponent, ok := strings.Cut(text, "e")
+	mantissa = strings.TrimSuffix(mantissa, ".")
+	if ok {
+		return mantissa + "e" + exponent
+	}
+	return mantissa
+}
@@ -0,0 +1,88 @@
+package calc
+
+import "testing"
+
+// Совпадают ли деревья разбора без учёта позиций и записи чисел
+func sameTree(a, b *Node) bool {
+	if a.Kind != b.Kind || a.Operator != b.Operator || len(a.Args) != len(b.Args) {
+		return false
+	}
+	switch a.Kind {
+	case NumberNode:
+		return a.Value == b.Value && canonicalNumber(a.Text) == canonicalNumber(b.Text)
+	case VariableNode:
+		return a.Text == b.Text
+	}
+	for i := range a.Args {
+		if !sameTree(a.Args[i], b.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCanonicalRoundTrip(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+	}{
+		{"1+2*3", "1 + 2 * 3"},
+		{"(1+2)*3", "(1 + 2) * 3"},
+		{"a-(b-c)", "a - (b - c)"},
+		{"(a-b)-c", "a - b - c"},
+		{"a/(b*c)", "a / (b * c)"},
+		{"2^3^4", "2 ^ 3 ^ 4"},
+		{"2^(3^4)", "2 ^ 3 ^ 4"},
+		{"(2^3)^4", "(2 ^ 3) ^ 4"},
+		{"2**3", "2 ^ 3"},
+		{"(a*b)^c", "(a * b) ^ c"},
+		{"-2^2", "-2 ^ 2"},
+		{"(-2)^2", "(-2) ^ 2"},
+		{"2^-3", "2 ^ (-3)"},
+		{"2^(-3)^2", "2 ^ (-3) ^ 2"},
+		{"-(2+3)", "-(2 + 3)"},
+		{"-(-a)", "--a"},
+		{"2 - -3", "2 - -3"},
+		{"2*+4", "2 * (+4)"},
+		{"+a", "+a"},
+		{"!a && b", "!a && b"},
+		{"!(a && b)", "!(a && b)"},
+		{"(a < b) == c", "(a < b) == c"},
+		{"a < (b + 1)", "a < b + 1"},
+		{"a ? b : c", "a ? b : c"},
+		{"if(a, b, c)", "a ? b : c"},
+		{"a ? b : c ? d : e", "a ? b : c ? d : e"},
+		{"a ? (b ? c : d) : e", "a ? b ? c : d : e"},
+		{"(a ? b : c) ? d : e", "(a ? b : c) ? d : e"},
+		{"1 + (a ? b : c)", "1 + (a ? b : c)"},
+		{"(a ? b : c) ^ 2", "(a ? b : c) ^ 2"},
+		{"-(a ? b : c)", "-(a ? b : c)"},
+		{"a || b ? c + 1 : -d", "a || b ? c + 1 : -d"},
+		{"max(1,2^3,(4))", "max(1, 2 ^ 3, 4)"},
+		{"pow(-a, b) ^ 2", "pow(-a, b) ^ 2"},
+		{".5 + 2.", "0.5 + 2"},
+		{"1E3 * 0X1f", "1e3 * 0x1F"},
+	}
+	for _, test := range tests {
+		tree, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		got := Canonical(tree)
+		if got != test.canonical {
+			t.Errorf("%q: canonical %q, want %q", test.expression, got, test.canonical)
+		}
+		reparsed, err := Parse(got)
+		if err != nil {
+			t.Errorf("%q: canonical %q does not parse: %v", test.expression, got, err)
+			continue
+		}
+		if !sameTree(tree, reparsed) {
+			t.Errorf("%q: canonical %q parses to a different tree", test.expression, got)
+		}
+		if again := Canonical(reparsed); again != got {
+			t.Errorf("%q: canonical form is not stable: %q, then %q", test.expression, got, again)
+		}
+	}
+}
@@ -0,0 +1,434 @@
+package calc
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Наибольшее целое, которое точно представимо в float64: константы сворачиваются при упрощении,
+// только если числитель и знаменатель результата не больше него
+var maxFoldedInteger = new(big.Rat).SetInt64(1 << 53)
+
+// Производная выражения по переменной variable, упрощённая Simplify.
+// Сравнения, логические операторы, // и round кусочно постоянны, их производная - 0.
+// Условный оператор дифференцируется по ветвям: (c ? a : b)' = c ? a' : b'.
+// Степень, показатель которой зависит от variable, не поддерживается: в калькуляторе нет логарифма.
+func Derivative(node *Node, variable string) (*Node, error) {
+	res, err := derivative(node, variable)
+	if err != nil {
+		return nil, err
+	}
+	return Simplify(res), nil
+}
+
+// Разбирает выражение формата format и возвращает каноническую запись его производной по variable
+func DerivativeExpression(expression, format, variable string) (string, error) {
+	if !IsVariableName(variable) {
+		return "", fmt.Errorf("invalid variable name %q", variable)
+	}
+	tree, err := ParseFormat(expression, format)
+	if err != nil {
+		return "", err
+	}
+	res, err := Derivative(tree, variable)
+	if err != nil {
+		return "", err
+	}
+	return Canonical(res), nil
+}
+
+// Проверяет, что name можно использовать как имя переменной
+func IsVariableName(name string) bool {
+	if name == "" || !isIdentStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdentPart(name[i]) {
+			return false
+		}
+	}
+	_, ok := functions[name]
+	return !ok
+}
+
+func derivative(node *Node, x string) (*Node, error) {
+	if !dependsOn(node, x) {
+		return number(node, big.NewRat(0, 1)), nil
+	}
+	switch node.Kind {
+	case VariableNode:
+		return number(node, big.NewRat(1, 1)), nil
+	case OperatorNode:
+		return operatorDerivative(node, x)
+	}
+	return functionDerivative(node, x)
+}
+
+func operatorDerivative(node *Node, x string) (*Node, error) {
+	args := make([]*Node, len(node.Args))
+	for i, arg := range node.Args {
+		d, err := derivative(arg, x)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = d
+	}
+	if len(node.Args) == 1 {
+		switch node.Operator {
+		case "+", "-":
+			return unary(node, node.Operator, args[0]), nil
+		}
+		return number(node, big.NewRat(0, 1)), nil
+	}
+	a, b := node.Args[0], node.Args[1]
+	da, db := args[0], args[1]
+	switch node.Operator {
+	case "+", "-":
+		return binary(node, node.Operator, da, db), nil
+	case "*":
+		// (ab)' = a'b + ab'
+		return binary(node, "+", binary(node, "*", da, b), binary(node, "*", a, db)), nil
+	case "/":
+		if !dependsOn(b, x) {
+			return binary(node, "/", da, b), nil
+		}
+		// (a/b)' = (a'b - ab') / b^2
+		numerator := binary(node, "-", binary(node, "*", da, b), binary(node, "*", a, db))
+		return binary(node, "/", numerator, binary(node, "^", b, number(node, big.NewRat(2, 1)))), nil
+	case "%":
+		// a % b = a - b * (a // b), a // b кусочно постоянно
+		return binary(node, "-", da, binary(node, "*", db, binary(node, "//", a, b))), nil
+	case "^":
+		return powerDerivative(node, a, b, da, x)
+	}
+	// Сравнения, логические операторы и //
+	return number(node, big.NewRat(0, 1)), nil
+}
+
+// (a^b)' = b * a^(b - 1) * a' для показателя, не зависящего от переменной
+func powerDerivative(node, a, b, da *Node, x string) (*Node, error) {
+	if dependsOn(b, x) {
+		return nil, &SyntaxError{Message: fmt.Sprintf("cannot differentiate a power whose exponent depends on %q", x), Column: node.Column, Token: node.Operator}
+	}
+	exponent := binary(node, "-", b, number(node, big.NewRat(1, 1)))
+	return binary(node, "*", binary(node, "*", b, binary(node, "^", a, exponent)), da), nil
+}
+
+func functionDerivative(node *Node, x string) (*Node, error) {
+	args := node.Args
+	switch node.Operator {
+	case "sqrt":
+		// sqrt(a)' = a' / (2 * sqrt(a))
+		da, err := derivative(args[0], x)
+		if err != nil {
+			return nil, err
+		}
+		return binary(node, "/", da, binary(node, "*", number(node, big.NewRat(2, 1)), node)), nil
+	case "abs":
+		// abs(a)' = a < 0 ? -a' : a'
+		da, err := derivative(args[0], x)
+		if err != nil {
+			return nil, err
+		}
+		return conditional(node, binary(node, "<", args[0], number(node, big.NewRat(0, 1))), unary(node, "-", da), da), nil
+	case "pow":
+		da, err := derivative(args[0], x)
+		if err != nil {
+			return nil, err
+		}
+		return powerDerivative(node, args[0], args[1], da, x)
+	case "min", "max":
+		return extremumDerivative(node, args, x)
+	case ConditionalOperator:
+		a, err := derivative(args[1], x)
+		if err != nil {
+			return nil, err
+		}
+		b, err := derivative(args[2], x)
+		if err != nil {
+			return nil, err
+		}
+		return conditional(node, args[0], a, b), nil
+	}
+	// round
+	return number(node, big.NewRat(0, 1)), nil
+}
+
+// min(a1, ..., an)' = an < min(a1, ..., an-1) ? an' : min(a1, ..., an-1)', для max - с >
+func extremumDerivative(node *Node, args []*Node, x string) (*Node, error) {
+	last := args[len(args)-1]
+	dlast, err := derivative(last, x)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		return dlast, nil
+	}
+	rest := args[0]
+	if len(args) > 2 {
+		rest = &Node{Kind: FunctionNode, Operator: node.Operator, Args: args[:len(args)-1], Column: node.Column}
+	}
+	drest, err := extremumDerivative(node, args[:len(args)-1], x)
+	if err != nil {
+		return nil, err
+	}
+	compare := "<"
+	if node.Operator == "max" {
+		compare = ">"
+	}
+	return conditional(node, binary(node, compare, last, rest), dlast, drest), nil
+}
+
+// Зависит ли выражение от переменной x
+func dependsOn(node *Node, x string) bool {
+	if node.Kind == VariableNode {
+		return node.Text == x
+	}
+	for _, arg := range node.Args {
+		if dependsOn(arg, x) {
+			return true
+		}
+	}
+	return false
+}
+
+// Упрощает выражение: сворачивает рациональные константы (1 + 2 - 3, 2 ^ 3, 0.5 - 1, 1 / 3 - 1) и убирает
+// нейтральные операнды (x + 0, x * 1, x ^ 1, x * 0, --x). Свёрнутая дробь записывается десятичным числом,
+// если это возможно без округления, иначе делением целых (2 / 3), так что в режиме exact значение не меняется.
+func Simplify(node *Node) *Node {
+	if node.Kind == NumberNode || node.Kind == VariableNode {
+		return node
+	}
+	res := *node
+	res.Args = make([]*Node, len(node.Args))
+	for i, arg := range node.Args {
+		res.Args[i] = Simplify(arg)
+	}
+	if res.Kind == FunctionNode {
+		return simplifyFunction(&res)
+	}
+	if len(res.Args) == 1 {
+		return simplifyUnary(&res)
+	}
+	return simplifyBinary(&res)
+}
+
+func simplifyUnary(node *Node) *Node {
+	arg := node.Args[0]
+	switch node.Operator {
+	case "+":
+		return arg
+	case "-":
+		if v, ok := numberValue(arg); ok {
+			return number(node, v.Neg(v))
+		}
+		if arg.Kind == OperatorNode && len(arg.Args) == 1 && arg.Operator == "-" {
+			return arg.Args[0]
+		}
+	}
+	return node
+}
+
+func simplifyBinary(node *Node) *Node {
+	a, b := node.Args[0], node.Args[1]
+	va, aNumber := numberValue(a)
+	vb, bNumber := numberValue(b)
+	if aNumber && bNumber {
+		if v, ok := foldConstants(node.Operator, va, vb); ok {
+			return number(node, v)
+		}
+	}
+	switch node.Operator {
+	case "+":
+		switch {
+		case isValue(va, aNumber, 0):
+			return b
+		case isValue(vb, bNumber, 0):
+			return a
+		case isNegation(b):
+			return binary(node, "-", a, b.Args[0])
+		}
+	case "-":
+		switch {
+		case isValue(vb, bNumber, 0):
+			return a
+		case isValue(va, aNumber, 0):
+			return Simplify(unary(node, "-", b))
+		case isNegation(b):
+			return binary(node, "+", a, b.Args[0])
+		}
+	case "*":
+		switch {
+		case isValue(va, aNumber, 0) || isValue(vb, bNumber, 0):
+			return number(node, big.NewRat(0, 1))
+		case isValue(va, aNumber, 1):
+			return b
+		case isValue(vb, bNumber, 1):
+			return a
+		case isValue(va, aNumber, -1):
+			return Simplify(unary(node, "-", b))
+		case isValue(vb, bNumber, -1):
+			return Simplify(unary(node, "-", a))
+		case aNumber && b.Kind == OperatorNode && b.Operator == "*" && len(b.Args) == 2:
+			// 3 * (2 * x) = 6 * x
+			if vc, ok := numberValue(b.Args[0]); ok {
+				if v, ok := foldConstants("*", va, vc); ok {
+					return Simplify(binary(node, "*", number(node, v), b.Args[1]))
+				}
+			}
+		}
+	case "/":
+		switch {
+		case isValue(vb, bNumber, 1):
+			return a
+		case isValue(va, aNumber, 0) && !isValue(vb, bNumber, 0):
+			return a
+		}
+	case "^":
+		switch {
+		case isValue(vb, bNumber, 0):
+			return number(node, big.NewRat(1, 1))
+		case isValue(vb, bNumber, 1):
+			return a
+		case isValue(va, aNumber, 1):
+			return a
+		}
+	}
+	return node
+}
+
+func simplifyFunction(node *Node) *Node {
+	if node.Operator != ConditionalOperator {
+		return node
+	}
+	cond, a, b := node.Args[0], node.Args[1], node.Args[2]
+	if v, ok := numberValue(cond); ok {
+		if v.Sign() != 0 {
+			return a
+		}
+		return b
+	}
+	// Одинаковые ветви: условие не нужно
+	if Canonical(a) == Canonical(b) {
+		return a
+	}
+	return node
+}
+
+// Сворачивает операцию над рациональными числами, если числитель и знаменатель результата точно представимы в float64.
+// Степень сворачивается только для целого показателя.
+func foldConstants(operator string, a, b *big.Rat) (*big.Rat, bool) {
+	res := new(big.Rat)
+	switch operator {
+	case "+":
+		res.Add(a, b)
+	case "-":
+		res.Sub(a, b)
+	case "*":
+		res.Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, false
+		}
+		res.Quo(a, b)
+	case "^":
+		if !b.IsInt() || b.Num().BitLen() > 6 || (a.Sign() == 0 && b.Sign() < 0) {
+			return nil, false
+		}
+		e := new(big.Int).Abs(b.Num())
+		res.SetFrac(new(big.Int).Exp(a.Num(), e, nil), new(big.Int).Exp(a.Denom(), e, nil))
+		if b.Sign() < 0 {
+			res.Inv(res)
+		}
+	default:
+		return nil, false
+	}
+	if new(big.Rat).SetInt(new(big.Int).Abs(res.Num())).Cmp(maxFoldedInteger) > 0 || new(big.Rat).SetInt(res.Denom()).Cmp(maxFoldedInteger) > 0 {
+		return nil, false
+	}
+	return res, true
+}
+
+// Значение константы: числа, числа с унарным минусом или деления двух чисел (так number записывает дроби)
+func numberValue(node *Node) (*big.Rat, bool) {
+	switch {
+	case node.Kind == NumberNode:
+		v, err := exactLiteral(node.Text)
+		return v, err == nil
+	case node.Kind == OperatorNode && len(node.Args) == 1 && node.Operator == "-" && node.Args[0].Kind == NumberNode:
+		v, err := exactLiteral(node.Args[0].Text)
+		if err != nil {
+			return nil, false
+		}
+		return v.Neg(v), true
+	case node.Kind == OperatorNode && len(node.Args) == 2 && node.Operator == "/" && node.Args[0].Kind == NumberNode && node.Args[1].Kind == NumberNode:
+		a, errA := exactLiteral(node.Args[0].Text)
+		b, errB := exactLiteral(node.Args[1].Text)
+		if errA != nil || errB != nil || b.Sign() == 0 {
+			return nil, false
+		}
+		return a.Quo(a, b), true
+	}
+	return nil, false
+}
+
+func isValue(v *big.Rat, ok bool, n int64) bool {
+	return ok && v.Cmp(big.NewRat(n, 1)) == 0
+}
+
+func isNegation(node *Node) bool {
+	return node.Kind == OperatorNode && len(node.Args) == 1 && node.Operator == "-"
+}
+
+// Узлы производной получают позицию узла исходного выражения, из которого они построены.
+// Дробь, у которой нет конечной десятичной записи, записывается делением целых: 2 / 3.
+func number(at *Node, v *big.Rat) *Node {
+	if v.Sign() < 0 {
+		abs := new(big.Rat).Neg(v)
+		return unary(at, "-", number(at, abs))
+	}
+	text := v.RatString()
+	if !v.IsInt() {
+		digits, ok := decimalDigits(v.Denom())
+		if !ok {
+			return binary(at, "/", number(at, new(big.Rat).SetInt(v.Num())), number(at, new(big.Rat).SetInt(v.Denom())))
+		}
+		text = v.FloatString(digits)
+	}
+	value, _ := v.Float64()
+	return &Node{Kind: NumberNode, Value: value, Text: text, Column: at.Column}
+}
+
+// Число знаков после точки в десятичной записи дроби со знаменателем denom, если она конечна
+// (знаменатель - произведение двоек и пятёрок)
+func decimalDigits(denom *big.Int) (int, bool) {
+	d := new(big.Int).Set(denom)
+	twos, fives := 0, 0
+	for _, p := range []struct {
+		factor int64
+		count  *int
+	}{{2, &twos}, {5, &fives}} {
+		factor, mod := big.NewInt(p.factor), new(big.Int)
+		for {
+			q, r := new(big.Int).QuoRem(d, factor, mod)
+			if r.Sign() != 0 {
+				break
+			}
+			d = q
+			*p.count++
+		}
+	}
+	return max(twos, fives), d.IsInt64() && d.Int64() == 1
+}
+
+func unary(at *Node, operator string, arg *Node) *Node {
+	return &Node{Kind: OperatorNode, Operator: operator, Args: []*Node{arg}, Column: at.Column}
+}
+
+func binary(at *Node, operator string, a, b *Node) *Node {
+	return &Node{Kind: OperatorNode, Operator: operator, Args: []*Node{a, b}, Column: at.Column}
+}
+
+func conditional(at *Node, cond, a, b *Node) *Node {
+	return &Node{Kind: FunctionNode, Operator: ConditionalOperator, Args: []*Node{cond, a, b}, Column: at.Column}
+}
@@ -0,0 +1,109 @@
+package calc
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// Значение дерева при x, вычисленное теми же операциями, что выполняют агенты
+func evalAt(t *testing.T, node *Node, x float64) float64 {
+	t.Helper()
+	switch node.Kind {
+	case NumberNode:
+		return node.Value
+	case VariableNode:
+		return x
+	}
+	args := make([]float64, len(node.Args))
+	for i, arg := range node.Args {
+		args[i] = evalAt(t, arg, x)
+	}
+	res, err := Operation{Operator: node.Operator}.eval(args)
+	if err != nil {
+		t.Fatalf("%q at x = %v: %v", Canonical(node), x, err)
+	}
+	return res
+}
+
+func TestDerivativeFiniteDifference(t *testing.T) {
+	const h = 1e-6
+	tests := []struct {
+		expression string
+		// Точки вдали от изломов и разрывов
+		points []float64
+	}{
+		{"x^3 - 2*x + 1", []float64{-1.5, 0.5, 2}},
+		{"x^0.5", []float64{0.25, 1, 4}},
+		{"x^-2", []float64{-2, 0.5, 3}},
+		{"(x^2 + 1)^(1/3)", []float64{-1, 0.5, 2}},
+		{"sqrt(x)", []float64{0.5, 1, 9}},
+		{"sqrt(x*x + 1)", []float64{-2, 0, 3}},
+		{"pow(x, 3)", []float64{-1, 2}},
+		{"pow(2*x + 1, 0.5)", []float64{0.5, 4}},
+		{"x / (x + 1)", []float64{0.5, 2}},
+		{"min(x, 2)", []float64{1, 3}},
+		{"max(x*x, 3, x)", []float64{-2, 0.5, 2.5}},
+		{"abs(x - 1)", []float64{0, 3}},
+		{"abs(x*x - 4)", []float64{-3, 1, 3}},
+		{"x > 1 ? x^2 : 3*x", []float64{0, 2}},
+		{"if(x < 0, -x, sqrt(x))", []float64{-2, 4}},
+		{"x % 3 + x // 2", []float64{1.5, 4.5}},
+		{"round(x) + (x > 0)", []float64{-1.3, 0.7}},
+	}
+	for _, test := range tests {
+		tree, err := Parse(test.expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		d, err := Derivative(tree, "x")
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		for _, x := range test.points {
+			want := (evalAt(t, tree, x+h) - evalAt(t, tree, x-h)) / (2 * h)
+			got := evalAt(t, d, x)
+			if math.Abs(got-want) > 1e-5*math.Max(1, math.Abs(want)) {
+				t.Errorf("%q: derivative %q at x = %v is %v, finite difference %v", test.expression, Canonical(d), x, got, want)
+			}
+		}
+	}
+}
+
+func TestDerivativeCanonical(t *testing.T) {
+	tests := []struct {
+		expression string
+		// Каноническая запись производной ("" - синтаксическая ошибка)
+		derivative string
+	}{
+		{"x^0.5", "0.5 * x ^ (-0.5)"},
+		{"x^(1/3)", "1 / 3 * x ^ (-(2 / 3))"},
+		{"x^3", "3 * x ^ 2"},
+		{"3 * x * 0.5", "1.5"},
+		{"x / 4 + x / 3", "7 / 12"},
+		{"2 * y", "0"},
+		{"x^x", ""},
+		{"2^x", ""},
+		{"pow(2, x)", ""},
+		{"pow(x, x + 1)", ""},
+	}
+	for _, test := range tests {
+		got, err := DerivativeExpression(test.expression, FormatInfix, "x")
+		if test.derivative == "" {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("%q: expected a syntax error, got %q, %v", test.expression, got, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		if got != test.derivative {
+			t.Errorf("%q: derivative %q, want %q", test.expression, got, test.derivative)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Коды ошибок, с которыми завершается выражение
+const (
+	// Выражение не удалось разбить на операции
+	CodeInvalidExpression = "invalid_expression"
+	CodeDivisionByZero    = "division_by_zero"
+	CodeOverflow          = "overflow"
+	CodeNotANumber        = "not_a_number"
+	CodeUnknownOperator   = "unknown_operator"
+	CodeMalformedOperand  = "malformed_operand"
+	// Результат нельзя представить точной дробью (режим exact)
+	CodeNotExact = "not_exact"
+	// Остальные ошибки выполнения операции
+	CodeCalculationError = "calculation_error"
+	// Вычисление отменено пользователем (статус cancelled)
+	CodeCancelled = "cancelled"
+	// Операция не вернулась от агентов после нескольких повторных отправок
+	CodeTimedOut = "timed_out"
+)
+
+// Ошибка вычисления с кодом, который сохраняется вместе с выражением
+type OperationError struct {
+	Code    string
+	Message string
+}
+
+func (e *OperationError) Error() string {
+	return e.Message
+}
+
+func operationError(code string, format string, args ...interface{}) *OperationError {
+	return &OperationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Код ошибки err или fallback, если у ошибки нет кода
+func ErrorCode(err error, fallback string) string {
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		return opErr.Code
+	}
+	return fallback
+}
@@ -0,0 +1,141 @@
+package calc
+
+import "time"
+
+// Оценивает время вычисления операций ops, если одновременно выполняется не больше slots операций
+// (сумма числа горутин всех работающих агентов). duration - оставшееся время выполнения операции (0 для уже выполненных).
+// Операции невыбранных ветвей условных операций не учитываются. Пока условие не вычислено,
+// учитываются обе ветви, поэтому оценка - верхняя граница.
+func Estimate(ops []Operation, duration func(op Operation) time.Duration, slots int) time.Duration {
+	if slots <= 0 {
+		slots = 1
+	}
+	ops = activeOperations(ops)
+	index := make(map[string]int, len(ops))
+	for i, op := range ops {
+		index[op.OperationID] = i
+	}
+	durations := make([]time.Duration, len(ops))
+	// Число ещё не выполненных дочерних операций и родители каждой операции
+	pending := make([]int, len(ops))
+	parents := make([][]int, len(ops))
+	// Операции, вычисляющие условия условных операций
+	conditions := make(map[string]int)
+	for i, op := range ops {
+		durations[i] = max(duration(op), 0)
+		for _, parent := range op.Parents {
+			if j, ok := index[parent.OperationID]; ok {
+				parents[i] = append(parents[i], j)
+				pending[j]++
+				if ops[j].Operator == ConditionalOperator && parent.Position == 0 {
+					conditions[parent.OperationID] = i
+				}
+			}
+		}
+	}
+	// Операции ветви запускаются только после вычисления условия
+	for i, op := range ops {
+		if cond, ok := conditions[op.Guard]; ok {
+			parents[cond] = append(parents[cond], i)
+			pending[i]++
+		}
+	}
+	// Длина самого долгого пути от операции до корня: первыми запускаются операции критического пути
+	levels := make(map[int]time.Duration, len(ops))
+	var level func(i int) time.Duration
+	level = func(i int) time.Duration {
+		if v, ok := levels[i]; ok {
+			return v
+		}
+		res := time.Duration(0)
+		for _, j := range parents[i] {
+			res = max(res, level(j))
+		}
+		levels[i] = res + durations[i]
+		return levels[i]
+	}
+	ready := []int{}
+	for i := range ops {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	type running struct {
+		op     int
+		finish time.Duration
+	}
+	now := time.Duration(0)
+	busy := []running{}
+	for len(ready) > 0 || len(busy) > 0 {
+		for len(busy) < slots && len(ready) > 0 {
+			best := 0
+			for k := range ready {
+				if level(ready[k]) > level(ready[best]) {
+					best = k
+				}
+			}
+			i := ready[best]
+			ready = append(ready[:best], ready[best+1:]...)
+			busy = append(busy, running{op: i, finish: now + durations[i]})
+		}
+		first := 0
+		for k := range busy {
+			if busy[k].finish < busy[first].finish {
+				first = k
+			}
+		}
+		done := busy[first]
+		busy = append(busy[:first], busy[first+1:]...)
+		now = done.finish
+		for _, j := range parents[done.op] {
+			pending[j]--
+			if pending[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	return now
+}
+
+// Число операций на самом длинном пути зависимостей: столько шагов занимает вычисление при неограниченном числе агентов
+func Depth(ops []Operation) int {
+	return int(Estimate(ops, func(op Operation) time.Duration { return 1 }, len(ops)))
+}
+
+// Операции без операций невыбранных ветвей условных операций
+func activeOperations(ops []Operation) []Operation {
+	byID := make(map[string]Operation, len(ops))
+	for _, op := range ops {
+		byID[op.OperationID] = op
+	}
+	skipped := make(map[string]bool)
+	var isSkipped func(op Operation) bool
+	isSkipped = func(op Operation) bool {
+		if op.Guard == "" {
+			return false
+		}
+		if v, ok := skipped[op.OperationID]; ok {
+			return v
+		}
+		res := false
+		if guard, ok := byID[op.Guard]; ok {
+			if len(guard.Args) > 0 && guard.Args[0] != nil {
+				chosen := 2
+				if IsTrue(guard.Args[0]) {
+					chosen = 1
+				}
+				res = op.Branch != chosen
+			}
+			res = res || isSkipped(guard)
+		}
+		skipped[op.OperationID] = res
+		return res
+	}
+	res := make([]Operation, 0, len(ops))
+	for _, op := range ops {
+		if !isSkipped(op) {
+			res = append(res, op)
+		}
+	}
+	return res
+}
@@ -0,0 +1,309 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Режимы вычисления выражения
+const (
+	// Числа с плавающей точкой (float64)
+	ModeFloat = "float"
+	// Точные рациональные дроби (big.Rat), значения передаются строками вида "1/3"
+	ModeExact = "exact"
+)
+
+// Наибольший показатель степени в точном режиме: дальше числитель и знаменатель растут неограниченно
+const maxExactExponent = 1024
+
+// Наибольшая длина числителя и знаменателя в точном режиме, в битах (около 20 000 десятичных цифр).
+// Большие дроби долго вычисляются и занимают много места в базе и в Redis.
+const maxExactBits = 1 << 16
+
+// Умещаются ли числитель и знаменатель x в maxExactBits
+func exactFits(x *big.Rat) bool {
+	return x.Num().BitLen() <= maxExactBits && x.Denom().BitLen() <= maxExactBits
+}
+
+func IsMode(mode string) bool {
+	return mode == "" || mode == ModeFloat || mode == ModeExact
+}
+
+var exactUnaryOperations = map[string]func(x *big.Rat) (*big.Rat, error){
+	"+": func(x *big.Rat) (*big.Rat, error) { return x, nil },
+	"-": func(x *big.Rat) (*big.Rat, error) { return new(big.Rat).Neg(x), nil },
+	"!": func(x *big.Rat) (*big.Rat, error) { return exactBool(x.Sign() == 0), nil },
+}
+
+var exactBinaryOperations = map[string]func(x, y *big.Rat) (*big.Rat, error){
+	"+": func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Add(x, y), nil },
+	"-": func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Sub(x, y), nil },
+	"*": func(x, y *big.Rat) (*big.Rat, error) { return new(big.Rat).Mul(x, y), nil },
+	"/": func(x, y *big.Rat) (*big.Rat, error) {
+		if y.Sign() == 0 {
+			return nil, operationError(CodeDivisionByZero, "division by zero")
+		}
+		return new(big.Rat).Quo(x, y), nil
+	},
+	"^":  exactPow,
+	"//": exactFloorDiv,
+	"%":  exactFloorMod,
+	"<":  func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Cmp(y) < 0), nil },
+	"<=": func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Cmp(y) <= 0), nil },
+	">":  func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Cmp(y) > 0), nil },
+	">=": func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Cmp(y) >= 0), nil },
+	"==": func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Cmp(y) == 0), nil },
+	"!=": func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Cmp(y) != 0), nil },
+	"&&": func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Sign() != 0 && y.Sign() != 0), nil },
+	"||": func(x, y *big.Rat) (*big.Rat, error) { return exactBool(x.Sign() != 0 || y.Sign() != 0), nil },
+}
+
+func exactBool(b bool) *big.Rat {
+	if b {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat)
+}
+
+var exactFunctions = map[string]func(args []*big.Rat) (*big.Rat, error){
+	"sqrt": func(args []*big.Rat) (*big.Rat, error) { return exactSqrt(args[0]) },
+	"abs":  func(args []*big.Rat) (*big.Rat, error) { return new(big.Rat).Abs(args[0]), nil },
+	"min": func(args []*big.Rat) (*big.Rat, error) {
+		res := args[0]
+		for _, v := range args[1:] {
+			if v.Cmp(res) < 0 {
+				res = v
+			}
+		}
+		return res, nil
+	},
+	"max": func(args []*big.Rat) (*big.Rat, error) {
+		res := args[0]
+		for _, v := range args[1:] {
+			if v.Cmp(res) > 0 {
+				res = v
+			}
+		}
+		return res, nil
+	},
+	"pow":   func(args []*big.Rat) (*big.Rat, error) { return exactPow(args[0], args[1]) },
+	"round": exactRound,
+	ConditionalOperator: func(args []*big.Rat) (*big.Rat, error) {
+		if args[0].Sign() != 0 {
+			return args[1], nil
+		}
+		return args[2], nil
+	},
+}
+
+// Степень вычисляется точно только для целого показателя
+func exactPow(x, y *big.Rat) (*big.Rat, error) {
+	if !y.IsInt() {
+		return nil, operationError(CodeNotExact, "exponent %s is not an integer", y.RatString())
+	}
+	if !y.Num().IsInt64() || y.Num().Int64() > maxExactExponent || y.Num().Int64() < -maxExactExponent {
+		return nil, operationError(CodeOverflow, "exponent %s is too large", y.RatString())
+	}
+	n := y.Num().Int64()
+	if n < 0 {
+		if x.Sign() == 0 {
+			return nil, operationError(CodeDivisionByZero, "division by zero")
+		}
+		x = new(big.Rat).Inv(x)
+		n = -n
+	}
+	// Длина степени не больше длины основания, умноженной на показатель: проверка до вычисления
+	if int64(x.Num().BitLen())*n > maxExactBits || int64(x.Denom().BitLen())*n > maxExactBits {
+		return nil, operationError(CodeOverflow, "result of %s^%d is too large", x.RatString(), n)
+	}
+	e := big.NewInt(n)
+	num := new(big.Int).Exp(x.Num(), e, nil)
+	den := new(big.Int).Exp(x.Denom(), e, nil)
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// Наибольшее целое, не превосходящее x
+func ratFloor(x *big.Rat) *big.Int {
+	// Знаменатель big.Rat всегда положителен, а Div - евклидово деление, т.е. округление вниз
+	return new(big.Int).Div(x.Num(), x.Denom())
+}
+
+func exactFloorDiv(x, y *big.Rat) (*big.Rat, error) {
+	if y.Sign() == 0 {
+		return nil, operationError(CodeDivisionByZero, "division by zero")
+	}
+	return new(big.Rat).SetInt(ratFloor(new(big.Rat).Quo(x, y))), nil
+}
+
+func exactFloorMod(x, y *big.Rat) (*big.Rat, error) {
+	div, err := exactFloorDiv(x, y)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Sub(x, new(big.Rat).Mul(y, div)), nil
+}
+
+// Корень извлекается, только если он рационален
+func exactSqrt(x *big.Rat) (*big.Rat, error) {
+	if x.Sign() < 0 {
+		return nil, operationError(CodeNotANumber, "square root of negative number %s", x.RatString())
+	}
+	num := new(big.Int).Sqrt(x.Num())
+	den := new(big.Int).Sqrt(x.Denom())
+	res := new(big.Rat).SetFrac(num, den)
+	if new(big.Rat).Mul(res, res).Cmp(x) != 0 {
+		return nil, operationError(CodeNotExact, "square root of %s is irrational", x.RatString())
+	}
+	return res, nil
+}
+
+// Округление половины от нуля, как у math.Round
+func exactRound(args []*big.Rat) (*big.Rat, error) {
+	scale := big.NewRat(1, 1)
+	if len(args) == 2 {
+		digits := new(big.Rat).SetInt(ratTrunc(args[1]))
+		var err error
+		scale, err = exactPow(big.NewRat(10, 1), digits)
+		if err != nil {
+			return nil, err
+		}
+	}
+	x := new(big.Rat).Mul(args[0], scale)
+	half := big.NewRat(1, 2)
+	if x.Sign() < 0 {
+		x.Sub(x, half)
+	} else {
+		x.Add(x, half)
+	}
+	return new(big.Rat).Quo(new(big.Rat).SetInt(ratTrunc(x)), scale), nil
+}
+
+// Целая часть x (округление к нулю)
+func ratTrunc(x *big.Rat) *big.Int {
+	return new(big.Int).Quo(x.Num(), x.Denom())
+}
+
+// Точное значение записи числа из выражения
+func exactLiteral(text string) (*big.Rat, error) {
+	// Размер проверяется до разбора: запись 1e-999999 дала бы знаменатель из миллиона цифр
+	if exactLiteralBits(text) > maxExactBits {
+		return nil, operationError(CodeOverflow, "number %q is too large for exact mode", text)
+	}
+	v, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return nil, fmt.Errorf("malformed number %q", text)
+	}
+	return v, nil
+}
+
+// Оценка сверху длины числителя и знаменателя литерала в битах: каждая цифра записи,
+// в том числе добавленная десятичным порядком, занимает не больше 4 бит
+func exactLiteralBits(text string) int {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		return 4 * len(text)
+	}
+	mantissa, exp := text, 0
+	if i := strings.IndexAny(text, "eE"); i >= 0 {
+		mantissa = text[:i]
+		e, err := strconv.Atoi(text[i+1:])
+		if err != nil {
+			return math.MaxInt
+		}
+		exp = max(e, -e)
+	}
+	if exp > math.MaxInt/4-len(mantissa) {
+		return math.MaxInt
+	}
+	return 4 * (len(mantissa) + exp)
+}
+
+// Точное значение переменной. Используется кратчайшая десятичная запись float64,
+// поэтому значение 0.1 из JSON становится дробью 1/10.
+func exactFromFloat(v float64) *big.Rat {
+	res, _ := new(big.Rat).SetString(strconv.FormatFloat(v, 'g', -1, 64))
+	return res
+}
+
+func (op Operation) exactTask() (interface{}, error) {
+	args := make([]*big.Rat, len(op.Args))
+	for i, arg := range op.Args {
+		s, _ := arg.(string)
+		v, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return nil, operationError(CodeMalformedOperand, "malformed operand %q", s)
+		}
+		args[i] = v
+	}
+	var res *big.Rat
+	var err error
+	if f, ok := exactFunctions[op.Operator]; ok {
+		res, err = f(args)
+	} else if f, ok := exactUnaryOperations[op.Operator]; ok && len(args) == 1 {
+		res, err = f(args[0])
+	} else if f, ok := exactBinaryOperations[op.Operator]; ok && len(args) == 2 {
+		res, err = f(args[0], args[1])
+	} else {
+		return nil, operationError(CodeUnknownOperator, "unknown operator %q", op.Operator)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !exactFits(res) {
+		return nil, operationError(CodeOverflow, "result of %q is too large for exact mode", op.Operator)
+	}
+	return res.RatString(), nil
+}
+
+// Запись значения операнда или результата для хранения: число для float64, дробь для точного режима
+func FormatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return formatNumber(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// Значение из записи FormatValue
+func ParseValue(mode, s string) (interface{}, error) {
+	if mode == ModeExact {
+		if _, ok := new(big.Rat).SetString(s); !ok {
+			return nil, fmt.Errorf("malformed exact value %q", s)
+		}
+		return s, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// Истинность значения условия: любое ненулевое значение - истина
+func IsTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case float64:
+		return v != 0
+	case *big.Rat:
+		return v.Sign() != 0
+	case string:
+		if r, ok := new(big.Rat).SetString(v); ok {
+			return r.Sign() != 0
+		}
+	}
+	return false
+}
+
+// Приближённое значение float64 (для точного режима - значение дроби)
+func ApproximateValue(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case string:
+		if r, ok := new(big.Rat).SetString(v); ok {
+			f, _ := r.Float64()
+			return f
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package calc
+
+import "testing"
+
+func TestExactPowResultSize(t *testing.T) {
+	base, err := Operation{Operator: "^", Args: []interface{}{"10", "300"}, Mode: ModeExact}.Task(nil)
+	if err != nil {
+		t.Fatalf("10^300: unexpected error %v", err)
+	}
+	tests := []struct {
+		args []interface{}
+		code string
+	}{
+		{[]interface{}{base, "1000"}, CodeOverflow},
+		{[]interface{}{"1/" + base.(string), "1000"}, CodeOverflow},
+		{[]interface{}{base, "-1000"}, CodeOverflow},
+		{[]interface{}{"2", "1024"}, ""},
+		{[]interface{}{"3", "2000"}, CodeOverflow},
+	}
+	for _, test := range tests {
+		_, err := Operation{Operator: "^", Args: test.args, Mode: ModeExact}.Task(nil)
+		if got := ErrorCode(err, ""); got != test.code {
+			t.Errorf("%.20v^%v: error code %q, want %q (%v)", test.args[0], test.args[1], got, test.code, err)
+		}
+	}
+}
+
+func TestExactResultSize(t *testing.T) {
+	// Каждое умножение удваивает длину: результат больше maxExactBits не возвращается
+	x := "2"
+	for i := 0; i < 20; i++ {
+		res, err := Operation{Operator: "*", Args: []interface{}{x, x}, Mode: ModeExact}.Task(nil)
+		if err != nil {
+			if code := ErrorCode(err, ""); code != CodeOverflow {
+				t.Fatalf("error code %q, want %q", code, CodeOverflow)
+			}
+			return
+		}
+		x = res.(string)
+	}
+	t.Errorf("result of %d bits was not rejected", 1<<20)
+}
+
+func TestExactLiteralSize(t *testing.T) {
+	tests := []struct {
+		expression string
+		code       string
+	}{
+		{"1e-999999", CodeOverflow},
+		{"0.5e-30000", CodeOverflow},
+		{"1e-99999999999999999999", CodeOverflow},
+		{"2.5e-3 + 1", ""},
+		{"0xFFFFFFFF + 1", ""},
+		{"1e300 * 2", ""},
+	}
+	for _, test := range tests {
+		_, err := TransformExpressionToStack("e", test.expression, Options{Mode: ModeExact})
+		if got := ErrorCode(err, ""); got != test.code {
+			t.Errorf("%q: error code %q, want %q (%v)", test.expression, got, test.code, err)
+		}
+	}
+}
@@ -0,0 +1,543 @@
+package calc
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// Запись выражения в LaTeX: \frac{a}{b}, \cdot, \times, \left( \right), ^{} и \sqrt{},
+// а также все команды, которые выводит LaTeX, поэтому его запись можно отправить обратно
+const FormatLaTeX = "latex"
+
+// Назначение группы в фигурных скобках
+type latexGroup int
+
+const (
+	// Обычная группа {...} - то же, что круглые скобки
+	latexPlain latexGroup = iota
+	latexNumerator
+	latexDenominator
+	// Показатель степени ^{...}
+	latexExponent
+	// Аргумент \sqrt{...}
+	latexArgument
+	// Числитель и знаменатель \frac в \left\lfloor ... \right\rfloor - целочисленное деление
+	latexFloorNumerator
+	latexFloorDenominator
+)
+
+// Команды, которые записывают операторы инфиксной записи
+var latexOperatorCommands = map[string]string{
+	"\\cdot": "*", "\\times": "*", "\\bmod": "%",
+	"\\le": "<=", "\\leq": "<=", "\\ge": ">=", "\\geq": ">=", "\\ne": "!=", "\\neq": "!=",
+	"\\land": "&&", "\\lor": "||", "\\lnot": "!", "\\neg": "!",
+}
+
+// Закрывающие скобки для \left
+var latexRightBrackets = map[string]string{"(": ")", "|": "|", "\\lfloor": "\\rfloor"}
+
+// Открытая \left: скобка и глубина вложенности групп {...}, на которой она открыта
+type latexLeft struct {
+	bracket string
+	depth   int
+}
+
+// Открытое окружение \begin{cases} value & \text{if } cond \\ other & \text{otherwise} \end{cases}
+type latexCases struct {
+	begin Token
+	// Индексы в лексемах: начало значения, условия и второй ветви
+	start, cond, other int
+	// Этап: 0 - значение, 1 - условие, 2 - вторая ветвь, 3 - после \text{otherwise}
+	stage int
+	// Глубина вложенности групп и \left, на которой открыто окружение
+	depth int
+}
+
+// Переводит выражение LaTeX в лексемы инфиксной записи: \frac{a}{b} - ((a)/(b)), \sqrt{a} - sqrt(a),
+// {a} - (a), \cdot и \times - *. Позиции лексем указывают на исходную запись LaTeX.
+// Запись LaTeX(node) тоже разбирается: \lnot, \land, \lor, \bmod, \le, \ge, \ne, =, \mathit{}, \operatorname{},
+// \min, \max, \left|a\right| - abs(a), \left\lfloor \frac{a}{b} \right\rfloor - a // b и окружение cases - условный оператор.
+// Как и в LaTeX, показатель степени без фигурных скобок - один символ или команда: x^23 - это x^{2}3, а не x^{23}.
+func tokenizeLaTeX(expression string) ([]Token, error) {
+	tokens := []Token{}
+	column := func(i int) int {
+		return utf8.RuneCountInString(expression[:i]) + 1
+	}
+	groups := []latexGroup{}
+	// Группа, которая должна начаться следующей: числитель или знаменатель \frac, аргумент \sqrt
+	expected, expectedBy := latexPlain, Token{}
+	expecting := false
+	// Глубины вложенности групп, на которых заканчиваются показатели-команды (x^\frac{1}{2}, x^\sqrt{2})
+	scripts := []int{}
+	// Следующая команда - показатель степени
+	scriptCommand := false
+	// Предыдущая лексема закончила показатель степени: второй ^ у того же основания - ошибка
+	scripted := false
+	lefts := []latexLeft{}
+	// После \left\lfloor должна идти \frac, а после её знаменателя - \right\rfloor
+	floorCommand, expectRfloor := false, false
+	cases := []latexCases{}
+	depth := func() int {
+		return len(groups) + len(lefts)
+	}
+	for i := 0; i < len(expression); {
+		if expression[i] == ' ' || expression[i] == '\t' || expression[i] == '\n' || expression[i] == '\r' {
+			i++
+			continue
+		}
+		wasScripted := scripted
+		scripted = false
+		if expecting && expression[i] != '{' {
+			return []Token{}, unexpected(expectedBy, fmt.Sprintf("expected \"{\" after %s", expectedBy.Text))
+		}
+		if floorCommand && !strings.HasPrefix(expression[i:], "\\frac") {
+			return []Token{}, &SyntaxError{Message: "expected \\frac after \\left\\lfloor", Column: column(i), Token: expression[i : i+1]}
+		}
+		if expectRfloor && !strings.HasPrefix(expression[i:], "\\right\\rfloor") {
+			return []Token{}, &SyntaxError{Message: "expected \\right\\rfloor after \\frac", Column: column(i), Token: expression[i : i+1]}
+		}
+		switch expression[i] {
+		case '{':
+			group := latexPlain
+			if expecting {
+				group, expecting = expected, false
+			}
+			groups = append(groups, group)
+			tokens = append(tokens, Token{Kind: TokenLParen, Text: "{", Column: column(i)})
+			i++
+		case '}':
+			if len(groups) == 0 {
+				return []Token{}, &SyntaxError{Message: "unmatched \"}\"", Column: column(i), Token: "}"}
+			}
+			group := groups[len(groups)-1]
+			groups = groups[:len(groups)-1]
+			tokens = append(tokens, Token{Kind: TokenRParen, Text: "}", Column: column(i)})
+			switch group {
+			case latexNumerator:
+				tokens = append(tokens, Token{Kind: TokenOperator, Text: "/", Column: column(i)})
+				expected, expectedBy, expecting = latexDenominator, Token{Text: "\\frac", Column: column(i)}, true
+			case latexFloorNumerator:
+				tokens = append(tokens, Token{Kind: TokenOperator, Text: "//", Column: column(i)})
+				expected, expectedBy, expecting = latexFloorDenominator, Token{Text: "\\frac", Column: column(i)}, true
+			case latexDenominator:
+				tokens = append(tokens, Token{Kind: TokenRParen, Text: "}", Column: column(i)})
+			case latexFloorDenominator:
+				tokens = append(tokens, Token{Kind: TokenRParen, Text: "}", Column: column(i)})
+				expectRfloor = true
+			case latexExponent:
+				scripted = true
+			}
+			if (group == latexDenominator || group == latexArgument) && len(scripts) > 0 && scripts[len(scripts)-1] == len(groups) {
+				tokens = append(tokens, Token{Kind: TokenRParen, Text: "}", Column: column(i)})
+				scripts = scripts[:len(scripts)-1]
+				scripted = true
+			}
+			i++
+		case '^':
+			tok := Token{Kind: TokenOperator, Text: "^", Column: column(i)}
+			if wasScripted {
+				return []Token{}, unexpected(tok, "double superscript")
+			}
+			tokens = append(tokens, tok)
+			i++
+			for i < len(expression) && strings.ContainsRune(" \t\n\r", rune(expression[i])) {
+				i++
+			}
+			if i >= len(expression) || expression[i] == '}' {
+				return []Token{}, unexpected(tok, "expected exponent after ^")
+			}
+			switch expression[i] {
+			case '{':
+				expected, expectedBy, expecting = latexExponent, tok, true
+			case '\\':
+				scripts = append(scripts, len(groups))
+				scriptCommand = true
+				tokens = append(tokens, Token{Kind: TokenLParen, Text: "^", Column: tok.Column})
+			default:
+				// Показатель из одного символа
+				_, size := utf8.DecodeRuneInString(expression[i:])
+				part, err := Tokenize(expression[i : i+size])
+				if err != nil {
+					if syntaxErr, ok := err.(*SyntaxError); ok {
+						syntaxErr.Column = column(i)
+					}
+					return []Token{}, err
+				}
+				tokens = append(tokens, Token{Kind: TokenLParen, Text: "^", Column: tok.Column})
+				for _, t := range part[:len(part)-1] {
+					t.Column = column(i)
+					tokens = append(tokens, t)
+				}
+				tokens = append(tokens, Token{Kind: TokenRParen, Text: expression[i : i+size], Column: column(i)})
+				i += size
+				scripted = true
+			}
+		case '\\':
+			start := i
+			i++
+			for i < len(expression) && isLetter(expression[i]) {
+				i++
+			}
+			if i == start+1 && i < len(expression) {
+				i++
+			}
+			command := expression[start:i]
+			tok := Token{Text: command, Column: column(start)}
+			if scriptCommand {
+				scriptCommand = false
+				if command != "\\frac" && command != "\\sqrt" {
+					return []Token{}, unexpected(tok, fmt.Sprintf("%s cannot be an exponent", command))
+				}
+			}
+			if op, ok := latexOperatorCommands[command]; ok {
+				tokens = append(tokens, Token{Kind: TokenOperator, Text: op, Column: tok.Column})
+				continue
+			}
+			switch command {
+			case "\\frac":
+				tokens = append(tokens, Token{Kind: TokenLParen, Text: command, Column: tok.Column})
+				expected, expectedBy, expecting = latexNumerator, tok, true
+				if floorCommand {
+					expected, floorCommand = latexFloorNumerator, false
+				}
+			case "\\sqrt":
+				tokens = append(tokens, Token{Kind: TokenIdent, Text: "sqrt", Column: tok.Column})
+				expected, expectedBy, expecting = latexArgument, tok, true
+			case "\\min", "\\max":
+				tokens = append(tokens, Token{Kind: TokenIdent, Text: command[1:], Column: tok.Column})
+			case "\\mathit", "\\operatorname":
+				text, next, ok := latexText(expression, i)
+				name := strings.ReplaceAll(text, "\\_", "_")
+				if !ok || !isIdentifier(name) {
+					return []Token{}, unexpected(tok, fmt.Sprintf("expected a name in braces after %s", command))
+				}
+				tokens = append(tokens, Token{Kind: TokenIdent, Text: name, Column: tok.Column})
+				i = next
+			case "\\left":
+				bracket := ""
+				for _, b := range []string{"(", "|", "\\lfloor"} {
+					if strings.HasPrefix(expression[i:], b) {
+						bracket = b
+					}
+				}
+				switch bracket {
+				case "":
+					return []Token{}, unexpected(tok, "expected \"(\", \"|\" or \\lfloor after \\left")
+				case "|":
+					tokens = append(tokens, Token{Kind: TokenIdent, Text: "abs", Column: tok.Column})
+				case "\\lfloor":
+					floorCommand = true
+				}
+				tokens = append(tokens, Token{Kind: TokenLParen, Text: command + bracket, Column: tok.Column})
+				lefts = append(lefts, latexLeft{bracket: bracket, depth: len(groups)})
+				i += len(bracket)
+			case "\\right":
+				if len(lefts) == 0 || lefts[len(lefts)-1].depth != len(groups) {
+					return []Token{}, unexpected(tok, "unmatched \\right")
+				}
+				bracket := latexRightBrackets[lefts[len(lefts)-1].bracket]
+				if !strings.HasPrefix(expression[i:], bracket) {
+					return []Token{}, unexpected(tok, fmt.Sprintf("expected %q after \\right", bracket))
+				}
+				lefts = lefts[:len(lefts)-1]
+				expectRfloor = false
+				tokens = append(tokens, Token{Kind: TokenRParen, Text: command + bracket, Column: tok.Column})
+				i += len(bracket)
+			case "\\begin":
+				text, next, ok := latexText(expression, i)
+				if !ok || text != "cases" {
+					return []Token{}, unexpected(tok, "expected {cases} after \\begin")
+				}
+				cases = append(cases, latexCases{begin: tok, start: len(tokens), depth: depth()})
+				i = next
+			case "\\\\":
+				if len(cases) == 0 || cases[len(cases)-1].stage != 1 || cases[len(cases)-1].depth != depth() {
+					return []Token{}, unexpected(tok, "unexpected \\\\ outside of cases")
+				}
+				cases[len(cases)-1].other, cases[len(cases)-1].stage = len(tokens), 2
+			case "\\end":
+				text, next, ok := latexText(expression, i)
+				if !ok || text != "cases" {
+					return []Token{}, unexpected(tok, "expected {cases} after \\end")
+				}
+				if len(cases) == 0 || cases[len(cases)-1].stage != 3 || cases[len(cases)-1].depth != depth() {
+					return []Token{}, unexpected(tok, "expected \"value & \\text{if } condition \\\\ value & \\text{otherwise}\" in cases")
+				}
+				tokens = latexConditional(tokens, cases[len(cases)-1], tok)
+				cases = cases[:len(cases)-1]
+				i = next
+			default:
+				return []Token{}, unexpected(tok, fmt.Sprintf("unknown command %q", command))
+			}
+		default:
+			if latexSeparator(expression, i) && expression[i] == '=' {
+				tokens = append(tokens, Token{Kind: TokenOperator, Text: "==", Column: column(i)})
+				i++
+				break
+			}
+			if latexSeparator(expression, i) {
+				// Разделитель столбцов cases: за ним \text{if } или \text{otherwise}
+				tok := Token{Text: "&", Column: column(i)}
+				i++
+				for i < len(expression) && strings.ContainsRune(" \t\n\r", rune(expression[i])) {
+					i++
+				}
+				text, next, ok := "", i, strings.HasPrefix(expression[i:], "\\text")
+				if ok {
+					text, next, ok = latexText(expression, i+len("\\text"))
+				}
+				if len(cases) == 0 || !ok || cases[len(cases)-1].depth != depth() {
+					return []Token{}, unexpected(tok, "unexpected \"&\" outside of cases")
+				}
+				c := &cases[len(cases)-1]
+				switch {
+				case c.stage == 0 && strings.TrimSpace(text) == "if":
+					c.cond, c.stage = len(tokens), 1
+				case c.stage == 2 && strings.TrimSpace(text) == "otherwise":
+					c.stage = 3
+				default:
+					return []Token{}, unexpected(tok, fmt.Sprintf("unexpected \\text{%s} in cases", text))
+				}
+				i = next
+				break
+			}
+			// Обычная инфиксная запись до следующей команды или фигурной скобки
+			end := i
+			for end < len(expression) && !strings.ContainsRune("{}\\^", rune(expression[end])) && !latexSeparator(expression, end) {
+				end++
+			}
+			part, err := Tokenize(expression[i:end])
+			offset := column(i) - 1
+			if err != nil {
+				if syntaxErr, ok := err.(*SyntaxError); ok {
+					syntaxErr.Column += offset
+				}
+				return []Token{}, err
+			}
+			for _, tok := range part[:len(part)-1] {
+				tok.Column += offset
+				tokens = append(tokens, tok)
+			}
+			i = end
+		}
+	}
+	if expecting {
+		return []Token{}, unexpected(expectedBy, fmt.Sprintf("expected \"{\" after %s", expectedBy.Text))
+	}
+	if len(groups) > 0 || len(scripts) > 0 {
+		return []Token{}, &SyntaxError{Message: "unclosed \"{\"", Column: column(len(expression))}
+	}
+	if len(lefts) > 0 || floorCommand || expectRfloor {
+		return []Token{}, &SyntaxError{Message: "unclosed \\left", Column: column(len(expression))}
+	}
+	if len(cases) > 0 {
+		return []Token{}, unexpected(cases[len(cases)-1].begin, "unclosed \\begin{cases}")
+	}
+	tokens = append(tokens, Token{Kind: TokenEOF, Column: column(len(expression))})
+	return tokens, nil
+}
+
+// Одиночные & (разделитель столбцов cases) и = (равенство) - не операторы инфиксной записи, в отличие от &&, ==, <=, >= и !=
+func latexSeparator(s string, i int) bool {
+	if s[i] != '&' && s[i] != '=' {
+		return false
+	}
+	var prev, next byte
+	if i > 0 {
+		prev = s[i-1]
+	}
+	if i+1 < len(s) {
+		next = s[i+1]
+	}
+	if s[i] == '&' {
+		return prev != '&' && next != '&'
+	}
+	return !strings.ContainsRune("<>!=", rune(prev)) && next != '='
+}
+
+// Содержимое группы {...} с позиции i без разбора (для \mathit, \operatorname, \text, \begin и \end)
+// и позиция после неё
+func latexText(expression string, i int) (string, int, bool) {
+	if i >= len(expression) || expression[i] != '{' {
+		return "", i, false
+	}
+	end := strings.IndexByte(expression[i:], '}')
+	if end < 0 {
+		return "", i, false
+	}
+	return expression[i+1 : i+end], i + end + 1, true
+}
+
+func isIdentifier(name string) bool {
+	if name == "" || !isIdentStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isIdentPart(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Заменяет лексемы окружения cases c на ((условие) ? (значение) : (вторая ветвь))
+func latexConditional(tokens []Token, c latexCases, end Token) []Token {
+	value := append([]Token{}, tokens[c.start:c.cond]...)
+	cond := append([]Token{}, tokens[c.cond:c.other]...)
+	other := append([]Token{}, tokens[c.other:]...)
+	column := c.begin.Column
+	res := append(tokens[:c.start], Token{Kind: TokenLParen, Text: "\\begin{cases}", Column: column}, Token{Kind: TokenLParen, Text: "(", Column: column})
+	res = append(res, cond...)
+	res = append(res, Token{Kind: TokenRParen, Text: ")", Column: column}, Token{Kind: TokenQuestion, Text: "?", Column: column}, Token{Kind: TokenLParen, Text: "(", Column: column})
+	res = append(res, value...)
+	res = append(res, Token{Kind: TokenRParen, Text: ")", Column: column}, Token{Kind: TokenColon, Text: ":", Column: column}, Token{Kind: TokenLParen, Text: "(", Column: column})
+	res = append(res, other...)
+	return append(res, Token{Kind: TokenRParen, Text: ")", Column: end.Column}, Token{Kind: TokenRParen, Text: "\\end{cases}", Column: end.Column})
+}
+
+func isLetter(char byte) bool {
+	return ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z')
+}
+
+// Запись выражения в LaTeX по дереву разбора
+func LaTeX(node *Node) string {
+	switch node.Kind {
+	case NumberNode:
+		return latexNumber(node.Text)
+	case VariableNode:
+		if len(node.Text) == 1 {
+			return node.Text
+		}
+		return "\\mathit{" + strings.ReplaceAll(node.Text, "_", "\\_") + "}"
+	case OperatorNode:
+		if len(node.Args) == 1 {
+			operand := latexOperand(node, node.Args[0], false)
+			if node.Operator == "!" {
+				return "\\lnot " + operand
+			}
+			return node.Operator + operand
+		}
+		left, right := node.Args[0], node.Args[1]
+		switch node.Operator {
+		case "/":
+			return "\\frac{" + LaTeX(left) + "}{" + LaTeX(right) + "}"
+		case "//":
+			return "\\left\\lfloor \\frac{" + LaTeX(left) + "}{" + LaTeX(right) + "} \\right\\rfloor"
+		case "^":
+			return latexPower(left, right)
+		}
+		return latexOperand(node, left, false) + " " + latexOperators[node.Operator] + " " + latexOperand(node, right, true)
+	}
+	args := make([]string, len(node.Args))
+	for i, arg := range node.Args {
+		args[i] = LaTeX(arg)
+	}
+	switch node.Operator {
+	case "sqrt":
+		return "\\sqrt{" + args[0] + "}"
+	case "abs":
+		return "\\left|" + args[0] + "\\right|"
+	case "pow":
+		return latexPower(node.Args[0], node.Args[1])
+	case "min", "max":
+		return "\\" + node.Operator + "\\left(" + strings.Join(args, ", ") + "\\right)"
+	case ConditionalOperator:
+		return "\\begin{cases} " + args[1] + " & \\text{if } " + args[0] + " \\\\ " + args[2] + " & \\text{otherwise} \\end{cases}"
+	}
+	return "\\operatorname{" + node.Operator + "}\\left(" + strings.Join(args, ", ") + "\\right)"
+}
+
+// Запись бинарных операторов, которые пишутся между операндами
+var latexOperators = map[string]string{
+	"+": "+", "-": "-", "*": "\\cdot", "%": "\\bmod",
+	"<": "<", "<=": "\\le", ">": ">", ">=": "\\ge", "==": "=", "!=": "\\ne",
+	"&&": "\\land", "||": "\\lor",
+}
+
+func latexPower(base, exponent *Node) string {
+	res := LaTeX(base)
+	// Без скобок остаются только числа и переменные
+	if !(base.Kind == VariableNode || (base.Kind == NumberNode && !strings.Contains(res, "\\times"))) {
+		res = "\\left(" + res + "\\right)"
+	}
+	return res + "^{" + LaTeX(exponent) + "}"
+}
+
+// Наивысший приоритет: числа, переменные и функции
+const maxPrecedence = 10
+
+// Приоритет узла при записи: от него зависит, нужны ли скобки вокруг операнда
+func latexPrecedence(node *Node) int {
+	switch node.Kind {
+	case OperatorNode:
+		if len(node.Args) == 1 {
+			return prefixOperators[node.Operator]
+		}
+		// Дроби записываются \frac{}{} и не требуют скобок
+		if node.Operator == "/" || node.Operator == "//" {
+			return maxPrecedence
+		}
+		return binaryOperators[node.Operator].precedence
+	case FunctionNode:
+		if node.Operator == ConditionalOperator {
+			return conditionalPrecedence
+		}
+	}
+	return maxPrecedence
+}
+
+// Операнд оператора parent в скобках, если без них выражение читалось бы иначе
+func latexOperand(parent, operand *Node, right bool) string {
+	res := LaTeX(operand)
+	parentPrecedence, precedence := latexPrecedence(parent), latexPrecedence(operand)
+	parens := precedence < parentPrecedence
+	if precedence == parentPrecedence && len(parent.Args) == 2 {
+		// a - (b - c), сравнения и ^ с другой стороны
+		parens = right != binaryOperators[parent.Operator].rightAssoc || comparisonOperators[parent.Operator]
+	}
+	// -(-a) и 2 \cdot -3: отрицательный операнд справа
+	if operand.Kind == OperatorNode && len(operand.Args) == 1 && (right || len(parent.Args) == 1) {
+		parens = true
+	}
+	if parens {
+		return "\\left(" + res + "\\right)"
+	}
+	return res
+}
+
+// Число в записи LaTeX: 1e3 - 1\times 10^{3}
+func latexNumber(text string) string {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		return text
+	}
+	mantissa, exponent, ok := strings.Cut(strings.ToLower(text), "e")
+	if !ok {
+		return text
+	}
+	return mantissa + "\\times 10^{" + strings.TrimPrefix(exponent, "+") + "}"
+}
+
+// Значение результата в записи LaTeX: дробь точного режима - \frac{p}{q}
+func LaTeXValue(v interface{}) string {
+	s := FormatValue(v)
+	if r, ok := new(big.Rat).SetString(s); ok && !r.IsInt() && strings.Contains(s, "/") {
+		sign := ""
+		if r.Sign() < 0 {
+			sign = "-"
+		}
+		num := new(big.Int).Abs(r.Num())
+		return sign + "\\frac{" + num.String() + "}{" + r.Denom().String() + "}"
+	}
+	return latexNumber(s)
+}
+
+// Запись в LaTeX выражения, сохранённого в формате format
+func ExpressionLaTeX(expression, format string) (string, error) {
+	tree, err := ParseFormat(expression, format)
+	if err != nil {
+		return "", err
+	}
+	return LaTeX(tree), nil
+}
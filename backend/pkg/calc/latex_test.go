@@ -0,0 +1,126 @@
+package calc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLaTeXSuperscript(t *testing.T) {
+	tests := []struct {
+		expression string
+		// Каноническая запись разобранного выражения ("" - синтаксическая ошибка)
+		canonical string
+	}{
+		{"2^{10}", "2 ^ 10"},
+		{"2^3", "2 ^ 3"},
+		{"2^ 3", "2 ^ 3"},
+		{"x^2 + 1", "x ^ 2 + 1"},
+		{"2^{3} \\cdot 4", "2 ^ 3 * 4"},
+		{"2^3 \\cdot 4", "2 ^ 3 * 4"},
+		{"4^\\frac{1}{2}", "4 ^ (1 / 2)"},
+		{"4^\\sqrt{4}", "4 ^ sqrt(4)"},
+		{"{2^{2}}^{3}", "(2 ^ 2) ^ 3"},
+		{"2^{3^{2}}", "2 ^ 3 ^ 2"},
+		// x^23 в LaTeX - это x^{2}3, а не x^{23}
+		{"x^23", ""},
+		{"2^{2}^{3}", ""},
+		{"2^2^3", ""},
+		{"2^{2} ^3", ""},
+		{"4^\\frac{1}{2}^{3}", ""},
+		{"2^", ""},
+		{"{2^}", ""},
+		{"2^\\cdot 3", ""},
+	}
+	for _, test := range tests {
+		tree, err := ParseFormat(test.expression, FormatLaTeX)
+		if test.canonical == "" {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		if got := Canonical(tree); got != test.canonical {
+			t.Errorf("%q: got %q, want %q", test.expression, got, test.canonical)
+		}
+	}
+}
+
+func TestLaTeXRenderParse(t *testing.T) {
+	expressions := []string{
+		"a + b", "a - b", "a * b", "a / b", "a // b", "a % b", "a ^ b", "a ** b",
+		"a < b", "a <= b", "a > b", "a >= b", "a == b", "a != b",
+		"a && b", "a || b", "!a", "!1", "-a", "+a", "--a", "!(a && b)",
+		"a ? b : c", "if(a, b, c)", "(a ? b : c) ? d : e", "a ? b ? c : d : e", "1 + (a < b ? 1 : 2)",
+		"sqrt(a)", "abs(a - b)", "min(a, b, 3)", "max(1, 2)", "pow(a, b)", "round(a)", "round(a, 2)",
+		"rate * 2", "long_name + x_1",
+		"(a + b) * c", "a - (b - c)", "(a // b) // c", "(a % b) * c", "a * -b", "(a + b) ^ c ^ d",
+		"abs(a // b) + (a == b) * 2", "0x1F + 2.5",
+	}
+	for _, expression := range expressions {
+		tree, err := Parse(expression)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", expression, err)
+			continue
+		}
+		latex := LaTeX(tree)
+		back, err := ParseFormat(latex, FormatLaTeX)
+		if err != nil {
+			t.Errorf("%q: rendered %q does not parse: %v", expression, latex, err)
+			continue
+		}
+		if got := LaTeX(back); got != latex {
+			t.Errorf("%q: rendered %q parses to %q", expression, latex, got)
+		}
+	}
+}
+
+func TestParseLaTeXCommands(t *testing.T) {
+	tests := []struct {
+		expression string
+		canonical  string
+	}{
+		{"\\lnot a \\land b \\lor c", "!a && b || c"},
+		{"a \\le b", "a <= b"},
+		{"a = b", "a == b"},
+		{"a == b", "a == b"},
+		{"a <= b", "a <= b"},
+		{"a \\ne b", "a != b"},
+		{"a && b", "a && b"},
+		{"\\left\\lfloor \\frac{7}{2} \\right\\rfloor", "7 // 2"},
+		{"\\left|x\\right|", "abs(x)"},
+		{"\\max\\left(1, 2\\right)", "max(1, 2)"},
+		{"\\operatorname{round}\\left(x, 2\\right)", "round(x, 2)"},
+		{"\\mathit{long\\_name}", "long_name"},
+		{"\\begin{cases} 1 & \\text{if } x > 0 \\\\ 2 & \\text{otherwise} \\end{cases}", "x > 0 ? 1 : 2"},
+		{"\\left\\lfloor \\frac{7}{2} + 1 \\right\\rfloor", ""},
+		{"\\left\\lfloor 7 \\right\\rfloor", ""},
+		{"\\left( 1 \\right|", ""},
+		{"{\\left( 1 } \\right)", ""},
+		{"a & b", ""},
+		{"\\begin{cases} 1 & \\text{if } x \\end{cases}", ""},
+		{"\\begin{cases} 1 & \\text{if } x \\\\ 2 & \\text{otherwise}", ""},
+		{"\\operatorname{2x}\\left(1\\right)", ""},
+	}
+	for _, test := range tests {
+		tree, err := ParseFormat(test.expression, FormatLaTeX)
+		if test.canonical == "" {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+			continue
+		}
+		if got := Canonical(tree); got != test.canonical {
+			t.Errorf("%q: got %q, want %q", test.expression, got, test.canonical)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package calc
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	TokenOperator
+	TokenLParen
+	TokenRParen
+	TokenIdent
+	TokenComma
+	// "?" и ":" условного оператора
+	TokenQuestion
+	TokenColon
+)
+
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Value float64
+	// Номер символа в исходной строке, начиная с 1
+	Column int
+}
+
+func (t Token) String() string {
+	if t.Kind == TokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.Text)
+}
+
+// Ошибка разбора выражения с указанием места
+type SyntaxError struct {
+	Message string `json:"error"`
+	Column  int    `json:"column"`
+	Token   string `json:"token"`
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Message, e.Column)
+}
+
+// Операторы, которые распознаёт лексер
+var operatorSymbols = []string{"+", "-", "*", "/", "^", "**", "//", "%", "<", "<=", ">", ">=", "==", "!=", "&&", "||", "!"}
+
+// Разбивает выражение на лексемы. Последней всегда идёт лексема TokenEOF.
+func Tokenize(expression string) ([]Token, error) {
+	tokens := []Token{}
+	column := func(i int) int {
+		return utf8.RuneCountInString(expression[:i]) + 1
+	}
+	for i := 0; i < len(expression); {
+		char, size := utf8.DecodeRuneInString(expression[i:])
+		switch {
+		case unicode.IsSpace(char):
+			i += size
+		case isNumberStart(expression[i]):
+			text, v, next, err := scanNumber(expression, i)
+			if err != nil {
+				return []Token{}, &SyntaxError{Message: err.Error(), Column: column(i), Token: text}
+			}
+			tokens = append(tokens, Token{Kind: TokenNumber, Text: text, Value: v, Column: column(i)})
+			i = next
+		case char == '(':
+			tokens = append(tokens, Token{Kind: TokenLParen, Text: "(", Column: column(i)})
+			i += size
+		case char == ')':
+			tokens = append(tokens, Token{Kind: TokenRParen, Text: ")", Column: column(i)})
+			i += size
+		case char == ',':
+			tokens = append(tokens, Token{Kind: TokenComma, Text: ",", Column: column(i)})
+			i += size
+		case char == '?':
+			tokens = append(tokens, Token{Kind: TokenQuestion, Text: "?", Column: column(i)})
+			i += size
+		case char == ':':
+			tokens = append(tokens, Token{Kind: TokenColon, Text: ":", Column: column(i)})
+			i += size
+		case isIdentStart(expression[i]):
+			start := i
+			for i < len(expression) && isIdentPart(expression[i]) {
+				i++
+			}
+			tokens = append(tokens, Token{Kind: TokenIdent, Text: expression[start:i], Column: column(start)})
+		default:
+			op := matchOperator(expression[i:])
+			if op == "" {
+				return []Token{}, &SyntaxError{Message: fmt.Sprintf("unexpected character %q", char), Column: column(i), Token: string(char)}
+			}
+			tokens = append(tokens, Token{Kind: TokenOperator, Text: op, Column: column(i)})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, Token{Kind: TokenEOF, Column: column(len(expression))})
+	return tokens, nil
+}
+
+func isIdentStart(char byte) bool {
+	return ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z') || char == '_'
+}
+
+func isIdentPart(char byte) bool {
+	return isIdentStart(char) || ('0' <= char && char <= '9')
+}
+
+// Самый длинный оператор, с которого начинается строка
+func matchOperator(s string) string {
+	res := ""
+	for _, op := range operatorSymbols {
+		if strings.HasPrefix(s, op) && len(op) > len(res) {
+			res = op
+		}
+	}
+	return res
+}
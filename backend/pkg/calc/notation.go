@@ -0,0 +1,216 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+)
+
+// Форматы записи выражения
+const (
+	// Обычная инфиксная запись: 2 + 3 * 4
+	FormatInfix = "infix"
+	// Обратная польская (постфиксная) запись: 2 3 4 * +
+	FormatRPN = "rpn"
+	// Польская (префиксная) запись: + 2 * 3 4
+	FormatPrefix = "prefix"
+)
+
+// Проверяет, что формат записи поддерживается ("" - инфиксный)
+func IsFormat(format string) bool {
+	return format == "" || format == FormatInfix || format == FormatRPN || format == FormatPrefix || format == FormatLaTeX
+}
+
+// Разбирает выражение, записанное в формате format, в дерево.
+// Все форматы дают одинаковые деревья, поэтому дальше выражение обрабатывается одинаково.
+func ParseFormat(expression, format string) (*Node, error) {
+	tokens, err := tokenizeFormat(expression, format)
+	if err != nil {
+		return nil, err
+	}
+	return parseFormatTokens(tokens, format)
+}
+
+// Лексемы выражения: запись LaTeX сразу переводится в лексемы инфиксной записи
+func tokenizeFormat(expression, format string) ([]Token, error) {
+	if format == FormatLaTeX {
+		return tokenizeLaTeX(expression)
+	}
+	return Tokenize(expression)
+}
+
+func parseFormatTokens(tokens []Token, format string) (*Node, error) {
+	switch format {
+	case "", FormatInfix, FormatLaTeX:
+		return parseTokens(tokens)
+	case FormatRPN:
+		return parseRPN(tokens)
+	case FormatPrefix:
+		return parsePrefix(tokens)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Оператор или функция записи без скобок с числом операндов
+type notationOperator struct {
+	token Token
+	arity int
+}
+
+// Разбирает операнд или оператор с необязательным числом операндов (min:3, -:1).
+// По умолчанию операторы бинарные, "!" - унарный, функции принимают наименьшее допустимое число аргументов,
+// min и max - два. Возвращает nil для операнда.
+func (p *parser) notationOperator(format string) (*notationOperator, *Node, error) {
+	tok := p.next()
+	var op *notationOperator
+	switch tok.Kind {
+	case TokenNumber:
+		return nil, &Node{Kind: NumberNode, Value: tok.Value, Text: tok.Text, Column: tok.Column}, nil
+	case TokenIdent:
+		f, ok := functions[tok.Text]
+		if !ok {
+			return nil, &Node{Kind: VariableNode, Text: tok.Text, Column: tok.Column}, nil
+		}
+		op = &notationOperator{token: tok, arity: f.minArgs}
+		if f.maxArgs < 0 {
+			op.arity = max(f.minArgs, 2)
+		}
+	case TokenOperator:
+		if alias, ok := operatorAliases[tok.Text]; ok {
+			tok.Text = alias
+		}
+		op = &notationOperator{token: tok, arity: 2}
+		if _, ok := binaryOperators[tok.Text]; !ok {
+			op.arity = 1
+		}
+	case TokenLParen, TokenRParen, TokenComma:
+		return nil, nil, unexpected(tok, fmt.Sprintf("unexpected %s, parentheses and commas are not used in %s notation", tok, format))
+	default:
+		return nil, nil, unexpected(tok, fmt.Sprintf("unexpected %s, expected an operand or an operator", tok))
+	}
+	if p.peek().Kind == TokenColon {
+		// Операнды занимают хотя бы по одной лексеме: в польской записи они следуют за оператором, в обратной - перед ним
+		operands := p.pos - 1
+		p.next()
+		count := p.next()
+		if count.Kind != TokenNumber || count.Value != math.Trunc(count.Value) {
+			return nil, nil, unexpected(count, fmt.Sprintf("unexpected %s, expected the number of operands of %q", count, tok.Text))
+		}
+		if format == FormatPrefix {
+			operands = len(p.tokens) - 1 - p.pos
+		}
+		if count.Value > float64(operands) {
+			return nil, nil, unexpected(count, fmt.Sprintf("%q cannot take %s operands, the expression has only %s", tok.Text, count.Text, operandsCount(operands)))
+		}
+		op.arity = int(count.Value)
+	}
+	if err := op.check(); err != nil {
+		return nil, nil, err
+	}
+	return op, nil, nil
+}
+
+// Проверяет, что оператор принимает указанное число операндов
+func (op *notationOperator) check() error {
+	name := op.token.Text
+	if f, ok := functions[name]; ok {
+		if op.arity < f.minArgs || (f.maxArgs >= 0 && op.arity > f.maxArgs) {
+			return unexpected(op.token, fmt.Sprintf("function %q expects %s, got %d", name, f.arityString(), op.arity))
+		}
+		return nil
+	}
+	_, binary := binaryOperators[name]
+	_, prefix := prefixOperators[name]
+	if (op.arity == 2 && binary) || (op.arity == 1 && prefix) {
+		return nil
+	}
+	return unexpected(op.token, fmt.Sprintf("operator %q cannot take %s", name, operandsCount(op.arity)))
+}
+
+func (op *notationOperator) node(args []*Node) *Node {
+	kind := OperatorNode
+	if _, ok := functions[op.token.Text]; ok {
+		kind = FunctionNode
+	}
+	return &Node{Kind: kind, Operator: op.token.Text, Args: args, Column: op.token.Column}
+}
+
+// Обратная польская запись: операнды складываются в стек, оператор забирает из него свои операнды
+func parseRPN(tokens []Token) (*Node, error) {
+	p := &parser{tokens: tokens}
+	if p.peek().Kind == TokenEOF {
+		return nil, &SyntaxError{Message: "empty expression", Column: p.peek().Column}
+	}
+	stack := []*Node{}
+	for p.peek().Kind != TokenEOF {
+		op, operand, err := p.notationOperator(FormatRPN)
+		if err != nil {
+			return nil, err
+		}
+		if op == nil {
+			stack = append(stack, operand)
+			continue
+		}
+		if len(stack) < op.arity {
+			return nil, unexpected(op.token, fmt.Sprintf("%q expects %s, got %d", op.token.Text, operandsCount(op.arity), len(stack)))
+		}
+		args := append([]*Node{}, stack[len(stack)-op.arity:]...)
+		stack = append(stack[:len(stack)-op.arity], op.node(args))
+	}
+	if len(stack) > 1 {
+		extra := stack[1]
+		return nil, &SyntaxError{Message: fmt.Sprintf("%s left without an operator", operandsCount(len(stack)-1)), Column: extra.Column, Token: nodeToken(extra)}
+	}
+	return stack[0], nil
+}
+
+// Польская запись: за оператором следуют его операнды
+func parsePrefix(tokens []Token) (*Node, error) {
+	p := &parser{tokens: tokens}
+	if p.peek().Kind == TokenEOF {
+		return nil, &SyntaxError{Message: "empty expression", Column: p.peek().Column}
+	}
+	node, err := p.parsePrefixOperand()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != TokenEOF {
+		return nil, unexpected(tok, fmt.Sprintf("unexpected %s after the end of the expression", tok))
+	}
+	return node, nil
+}
+
+func (p *parser) parsePrefixOperand() (*Node, error) {
+	op, operand, err := p.notationOperator(FormatPrefix)
+	if err != nil {
+		return nil, err
+	}
+	if op == nil {
+		return operand, nil
+	}
+	args := make([]*Node, op.arity)
+	for i := range args {
+		if p.peek().Kind == TokenEOF {
+			return nil, unexpected(op.token, fmt.Sprintf("%q expects %s, got %d", op.token.Text, operandsCount(op.arity), i))
+		}
+		args[i], err = p.parsePrefixOperand()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return op.node(args), nil
+}
+
+func operandsCount(n int) string {
+	if n == 1 {
+		return "1 operand"
+	}
+	return fmt.Sprintf("%d operands", n)
+}
+
+// Текст узла для сообщения об ошибке
+func nodeToken(node *Node) string {
+	if node.Kind == NumberNode || node.Kind == VariableNode {
+		return node.Text
+	}
+	return node.Operator
+}
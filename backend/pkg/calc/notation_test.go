@@ -0,0 +1,36 @@
+package calc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseFormatArity(t *testing.T) {
+	tests := []struct {
+		expression string
+		format     string
+		ok         bool
+	}{
+		{"max:3 1 2 3", FormatPrefix, true},
+		{"1 2 3 max:3", FormatRPN, true},
+		{"max:1e18 1 2", FormatPrefix, false},
+		{"max:1e8 1 2", FormatPrefix, false},
+		{"max:1e30 1 2", FormatPrefix, false},
+		{"max:4 1 2 3", FormatPrefix, false},
+		{"1 2 max:1e18", FormatRPN, false},
+		{"1 2 max:1e30", FormatRPN, false},
+		{"max:2.5 1 2", FormatPrefix, false},
+	}
+	for _, test := range tests {
+		_, err := ParseFormat(test.expression, test.format)
+		if test.ok && err != nil {
+			t.Errorf("%s %q: unexpected error %v", test.format, test.expression, err)
+		}
+		if !test.ok {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("%s %q: expected a syntax error, got %v", test.format, test.expression, err)
+			}
+		}
+	}
+}
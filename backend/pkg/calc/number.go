@@ -0,0 +1,99 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+func isHexDigit(char byte) bool {
+	return ('0' <= char && char <= '9') || ('a' <= char && char <= 'f') || ('A' <= char && char <= 'F')
+}
+
+func isNumberStart(char byte) bool {
+	return ('0' <= char && char <= '9') || char == '.'
+}
+
+// Может ли символ продолжать числовой литерал: такие символы сразу после числа означают ошибку в записи.
+// Проверяются только символы ASCII: байт многобайтового символа UTF-8 не должен попасть в запись числа.
+func isNumberPart(char byte) bool {
+	return isNumberStart(char) || isIdentPart(char)
+}
+
+// Считывает числовой литерал, начинающийся с позиции start.
+// Поддерживаются целые (12), десятичные (1.5, .5, 2.), экспоненциальные (1e3, 2.5E-2) и шестнадцатеричные целые (0x1F) числа.
+// Возвращает текст литерала, его значение и позицию сразу после литерала.
+// При ошибке вместо текста литерала возвращается вся некорректная запись.
+func scanNumber(s string, start int) (string, float64, int, error) {
+	i := start
+	if i+1 < len(s) && s[i] == '0' && (s[i+1] == 'x' || s[i+1] == 'X') {
+		i += 2
+		for i < len(s) && isHexDigit(s[i]) {
+			i++
+		}
+		text := s[start:i]
+		if i == start+2 || (i < len(s) && isNumberPart(s[i])) {
+			return malformedNumber(s, start, i)
+		}
+		v, err := strconv.ParseUint(text[2:], 16, 64)
+		if err != nil {
+			return text, 0, start, fmt.Errorf("number %q is out of range", text)
+		}
+		return text, float64(v), i, nil
+	}
+	digits := 0
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+			digits++
+		}
+	}
+	if digits == 0 {
+		return malformedNumber(s, start, i)
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		expDigits := 0
+		for j < len(s) && '0' <= s[j] && s[j] <= '9' {
+			j++
+			expDigits++
+		}
+		if expDigits == 0 {
+			return malformedNumber(s, start, j)
+		}
+		i = j
+	}
+	text := s[start:i]
+	if i < len(s) && isNumberPart(s[i]) {
+		return malformedNumber(s, start, i)
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return text, 0, start, fmt.Errorf("number %q is out of range", text)
+		}
+		return text, 0, start, fmt.Errorf("malformed number %q", text)
+	}
+	return text, v, i, nil
+}
+
+// Ошибка для некорректного литерала: в запись включается всё, что продолжает его после позиции end
+func malformedNumber(s string, start, end int) (string, float64, int, error) {
+	for end < len(s) && isNumberPart(s[end]) {
+		end++
+	}
+	return s[start:end], 0, start, fmt.Errorf("malformed number %q", s[start:end])
+}
+
+// Запись числа, которую без потерь разбирает strconv.ParseFloat
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
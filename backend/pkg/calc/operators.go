@@ -0,0 +1,181 @@
+package calc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Время выполнения операции, если пользователь его не задал
+const DefaultTimeout = 10 * time.Second
+
+// Операции, которые выполняют агенты. Время выполнения настраивается по символу оператора,
+// поэтому унарный и бинарный минус используют одну настройку.
+var unaryOperations = map[string]func(x float64) float64{
+	"+": func(x float64) float64 { return x },
+	"-": func(x float64) float64 { return -x },
+	"!": func(x float64) float64 { return boolValue(x == 0) },
+}
+
+var binaryOperations = map[string]func(x, y float64) float64{
+	"+": func(x, y float64) float64 { return x + y },
+	"-": func(x, y float64) float64 { return x - y },
+	"*": func(x, y float64) float64 { return x * y },
+	"/": func(x, y float64) float64 { return x / y },
+	"^": math.Pow,
+	// Целочисленное деление и остаток согласованы: x == y*(x//y) + x%y,
+	// знак остатка совпадает со знаком делителя (-7 // 2 = -4, -7 % 2 = 1)
+	"//": floorDiv,
+	"%":  floorMod,
+	// Сравнения и логические операторы возвращают 1 (истина) или 0 (ложь), любое ненулевое значение - истина
+	"<":  func(x, y float64) float64 { return boolValue(x < y) },
+	"<=": func(x, y float64) float64 { return boolValue(x <= y) },
+	">":  func(x, y float64) float64 { return boolValue(x > y) },
+	">=": func(x, y float64) float64 { return boolValue(x >= y) },
+	"==": func(x, y float64) float64 { return boolValue(x == y) },
+	"!=": func(x, y float64) float64 { return boolValue(x != y) },
+	"&&": func(x, y float64) float64 { return boolValue(x != 0 && y != 0) },
+	"||": func(x, y float64) float64 { return boolValue(x != 0 || y != 0) },
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Условная операция: if(cond, then, else) и cond ? then : else.
+// Агенту она отправляется, когда известно условие и значение выбранной ветви.
+const ConditionalOperator = "if"
+
+// Функция, вызываемая в выражении как name(a, b, ...)
+type function struct {
+	minArgs int
+	// Отрицательное значение - число аргументов не ограничено
+	maxArgs int
+	eval    func(args []float64) float64
+}
+
+var functions = map[string]function{
+	"sqrt":  {minArgs: 1, maxArgs: 1, eval: func(args []float64) float64 { return math.Sqrt(args[0]) }},
+	"abs":   {minArgs: 1, maxArgs: 1, eval: func(args []float64) float64 { return math.Abs(args[0]) }},
+	"min":   {minArgs: 1, maxArgs: -1, eval: minOf},
+	"max":   {minArgs: 1, maxArgs: -1, eval: maxOf},
+	"pow":   {minArgs: 2, maxArgs: 2, eval: func(args []float64) float64 { return math.Pow(args[0], args[1]) }},
+	"round": {minArgs: 1, maxArgs: 2, eval: round},
+	ConditionalOperator: {minArgs: 3, maxArgs: 3, eval: func(args []float64) float64 {
+		if args[0] != 0 {
+			return args[1]
+		}
+		return args[2]
+	}},
+}
+
+// Описание допустимого числа аргументов для сообщений об ошибках
+func (f function) arityString() string {
+	switch {
+	case f.maxArgs < 0:
+		return "at least " + argumentsCount(f.minArgs)
+	case f.minArgs == f.maxArgs:
+		return argumentsCount(f.minArgs)
+	}
+	return fmt.Sprintf("from %d to %d arguments", f.minArgs, f.maxArgs)
+}
+
+func argumentsCount(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+func minOf(args []float64) float64 {
+	res := args[0]
+	for _, v := range args[1:] {
+		res = math.Min(res, v)
+	}
+	return res
+}
+
+func maxOf(args []float64) float64 {
+	res := args[0]
+	for _, v := range args[1:] {
+		res = math.Max(res, v)
+	}
+	return res
+}
+
+// round(x) округляет до целого, round(x, n) - до n знаков после запятой (половина округляется от нуля)
+func round(args []float64) float64 {
+	if len(args) == 1 {
+		return math.Round(args[0])
+	}
+	scale := math.Pow(10, math.Trunc(args[1]))
+	return math.Round(args[0]*scale) / scale
+}
+
+// Операторы деления, для которых делитель не может быть нулём
+var divisionOperators = map[string]bool{"/": true, "//": true, "%": true}
+
+func floorMod(x, y float64) float64 {
+	mod := math.Mod(x, y)
+	if mod != 0 && (mod < 0) != (y < 0) {
+		mod += y
+	}
+	return mod
+}
+
+func floorDiv(x, y float64) float64 {
+	mod := math.Mod(x, y)
+	div := (x - mod) / y
+	if mod != 0 && (mod < 0) != (y < 0) {
+		div -= 1
+	}
+	if div == 0 {
+		return math.Copysign(0, x/y)
+	}
+	// Поправка на погрешность деления (x - mod) / y
+	res := math.Floor(div)
+	if div-res > 0.5 {
+		res += 1
+	}
+	return res
+}
+
+// Список операторов и функций, для которых хранится время выполнения
+func Operators() []string {
+	set := map[string]bool{}
+	for op := range unaryOperations {
+		set[op] = true
+	}
+	for op := range binaryOperations {
+		set[op] = true
+	}
+	for name := range functions {
+		set[name] = true
+	}
+	res := make([]string, 0, len(set))
+	for op := range set {
+		res = append(res, op)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func IsOperator(operator string) bool {
+	_, unary := unaryOperations[operator]
+	_, binary := binaryOperations[operator]
+	_, function := functions[operator]
+	return unary || binary || function
+}
+
+// Время выполнения по умолчанию для всех операторов, в секундах
+func DefaultTimeouts() map[string]int {
+	res := make(map[string]int)
+	for _, op := range Operators() {
+		res[op] = int(DefaultTimeout.Seconds())
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package calc
+
+import "sort"
+
+// Ассоциативные и коммутативные операторы, цепочки которых можно перестраивать
+var associativeOperators = map[string]bool{"+": true, "*": true}
+
+// Перестраивает цепочки одинаковых ассоциативных операторов (+, *) в сбалансированные деревья,
+// чтобы уменьшить длину критического пути: 1+2+3+4+5+6+7+8 считается за 3 шага вместо 7.
+// Для float64 меняется порядок округлений, поэтому для режима float перестройка включается явно.
+func Balance(node *Node) *Node {
+	if node.Kind != OperatorNode && node.Kind != FunctionNode {
+		return node
+	}
+	if node.Kind == OperatorNode && len(node.Args) == 2 && associativeOperators[node.Operator] {
+		operands := flatten(node, node.Operator, nil)
+		for i := range operands {
+			operands[i] = Balance(operands[i])
+		}
+		return combine(node, operands)
+	}
+	res := *node
+	res.Args = make([]*Node, len(node.Args))
+	for i, arg := range node.Args {
+		res.Args[i] = Balance(arg)
+	}
+	return &res
+}
+
+// Операнды цепочки оператора operator слева направо
+func flatten(node *Node, operator string, operands []*Node) []*Node {
+	if node.Kind == OperatorNode && len(node.Args) == 2 && node.Operator == operator {
+		operands = flatten(node.Args[0], operator, operands)
+		return flatten(node.Args[1], operator, operands)
+	}
+	return append(operands, node)
+}
+
+// Число последовательных операций, необходимых для вычисления поддерева
+func depth(node *Node) int {
+	if len(node.Args) == 0 {
+		return 0
+	}
+	// Знак числа учитывается при разбиении без отдельной операции
+	if node.Kind == OperatorNode && len(node.Args) == 1 && len(node.Args[0].Args) == 0 {
+		return 0
+	}
+	res := 0
+	for _, arg := range node.Args {
+		res = max(res, depth(arg))
+	}
+	return res + 1
+}
+
+// Объединяет операнды цепочки, каждый раз соединяя два самых неглубоких поддерева.
+// Так получается дерево с минимальной глубиной; при равной глубине сохраняется исходный порядок операндов.
+func combine(root *Node, operands []*Node) *Node {
+	type item struct {
+		node  *Node
+		depth int
+		order int
+	}
+	items := make([]item, len(operands))
+	for i, operand := range operands {
+		items[i] = item{node: operand, depth: depth(operand), order: i}
+	}
+	for len(items) > 1 {
+		sort.SliceStable(items, func(i, j int) bool {
+			if items[i].depth != items[j].depth {
+				return items[i].depth < items[j].depth
+			}
+			return items[i].order < items[j].order
+		})
+		left, right := items[0], items[1]
+		if right.order < left.order {
+			left, right = right, left
+		}
+		node := &Node{Kind: OperatorNode, Operator: root.Operator, Args: []*Node{left.node, right.node}, Column: root.Column}
+		items = append(items[2:], item{node: node, depth: max(left.depth, right.depth) + 1, order: left.order})
+	}
+	return items[0].node
+}
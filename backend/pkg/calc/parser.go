@@ -0,0 +1,281 @@
+package calc
+
+import (
+	"fmt"
+)
+
+type NodeKind int
+
+const (
+	NumberNode NodeKind = iota
+	OperatorNode
+	FunctionNode
+	VariableNode
+)
+
+// Узел дерева разбора выражения
+type Node struct {
+	Kind NodeKind
+	// Значение и исходная запись числа (NumberNode) или имя переменной (VariableNode)
+	Value float64
+	Text  string
+	// Оператор и его операнды (OperatorNode), у унарного оператора один операнд.
+	// Для FunctionNode - имя функции и аргументы.
+	Operator string
+	Args     []*Node
+	// Позиция узла в исходной строке, начиная с 1
+	Column int
+}
+
+type binaryOperator struct {
+	precedence int
+	rightAssoc bool
+}
+
+// Приоритет условного оператора a ? b : c - самый низкий
+const conditionalPrecedence = 1
+
+var binaryOperators = map[string]binaryOperator{
+	"||": {precedence: 2},
+	"&&": {precedence: 3},
+	"<":  {precedence: 4},
+	"<=": {precedence: 4},
+	">":  {precedence: 4},
+	">=": {precedence: 4},
+	"==": {precedence: 4},
+	"!=": {precedence: 4},
+	"+":  {precedence: 5},
+	"-":  {precedence: 5},
+	"*":  {precedence: 6},
+	"/":  {precedence: 6},
+	"//": {precedence: 6},
+	"%":  {precedence: 6},
+	// Степень выше унарного минуса: -2^2 = -(2^2)
+	"^": {precedence: 8, rightAssoc: true},
+}
+
+// Операторы сравнения нельзя записывать цепочкой: a < b < c
+var comparisonOperators = map[string]bool{"<": true, "<=": true, ">": true, ">=": true, "==": true, "!=": true}
+
+// Приоритет префиксных операторов
+var prefixOperators = map[string]int{
+	"+": 7,
+	"-": 7,
+	"!": 7,
+}
+
+// Другие записи операторов
+var operatorAliases = map[string]string{
+	"**": "^",
+}
+
+type parser struct {
+	tokens []Token
+	pos    int
+}
+
+// Разбирает выражение в дерево
+func Parse(expression string) (*Node, error) {
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	return parseTokens(tokens)
+}
+
+func parseTokens(tokens []Token) (*Node, error) {
+	p := &parser{tokens: tokens}
+	if p.peek().Kind == TokenEOF {
+		return nil, &SyntaxError{Message: "empty expression", Column: p.peek().Column}
+	}
+	node, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	switch tok := p.peek(); tok.Kind {
+	case TokenEOF:
+		return node, nil
+	case TokenRParen:
+		return nil, unexpected(tok, "unmatched \")\"")
+	default:
+		return nil, unexpected(tok, fmt.Sprintf("unexpected %s, expected an operator", tok))
+	}
+}
+
+func (p *parser) peek() Token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func unexpected(tok Token, message string) *SyntaxError {
+	return &SyntaxError{Message: message, Column: tok.Column, Token: tok.Text}
+}
+
+// Разбор методом Пратта: поглощает бинарные операторы с приоритетом не ниже minPrecedence
+func (p *parser) parseExpression(minPrecedence int) (*Node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	comparison := false
+	for {
+		tok := p.peek()
+		if tok.Kind == TokenQuestion && conditionalPrecedence >= minPrecedence {
+			left, err = p.parseConditional(left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if tok.Kind != TokenOperator {
+			return left, nil
+		}
+		operator := tok.Text
+		if alias, ok := operatorAliases[operator]; ok {
+			operator = alias
+		}
+		op, ok := binaryOperators[operator]
+		if !ok || op.precedence < minPrecedence {
+			return left, nil
+		}
+		if comparisonOperators[operator] {
+			if comparison {
+				return nil, unexpected(tok, fmt.Sprintf("comparison %q cannot follow another comparison, use && or parentheses", operator))
+			}
+			comparison = true
+		}
+		p.next()
+		// "2++3" и "2-+3" скорее опечатка, чем унарный плюс; "2*+4" и "-+2" допустимы
+		if next := p.peek(); (operator == "+" || operator == "-") && next.Kind == TokenOperator && next.Text == "+" {
+			return nil, unexpected(next, fmt.Sprintf("unexpected %s after operator %q", next, tok.Text))
+		}
+		nextPrecedence := op.precedence + 1
+		if op.rightAssoc {
+			nextPrecedence = op.precedence
+		}
+		right, err := p.parseExpression(nextPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		left = &Node{Kind: OperatorNode, Operator: operator, Args: []*Node{left, right}, Column: tok.Column}
+	}
+}
+
+func (p *parser) parseOperand() (*Node, error) {
+	tok := p.next()
+	switch tok.Kind {
+	case TokenNumber:
+		return &Node{Kind: NumberNode, Value: tok.Value, Text: tok.Text, Column: tok.Column}, nil
+	case TokenLParen:
+		node, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().Kind != TokenRParen {
+			if p.peek().Kind == TokenEOF {
+				return nil, unexpected(tok, "unclosed \"(\"")
+			}
+			return nil, unexpected(p.peek(), fmt.Sprintf("unexpected %s, expected an operator or \")\"", p.peek()))
+		}
+		p.next()
+		return node, nil
+	case TokenIdent:
+		if _, ok := functions[tok.Text]; !ok && p.peek().Kind != TokenLParen {
+			return &Node{Kind: VariableNode, Text: tok.Text, Column: tok.Column}, nil
+		}
+		return p.parseCall(tok)
+	case TokenOperator:
+		precedence, ok := prefixOperators[tok.Text]
+		if !ok {
+			break
+		}
+		operand, err := p.parseExpression(precedence)
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Kind: OperatorNode, Operator: tok.Text, Args: []*Node{operand}, Column: tok.Column}, nil
+	}
+	return nil, unexpected(tok, fmt.Sprintf("unexpected %s, expected an operand", tok))
+}
+
+// Условный оператор cond ? then : else, правоассоциативный: a ? b : c ? d : e = a ? b : (c ? d : e).
+// Разбирается в тот же узел, что и вызов if(cond, then, else).
+func (p *parser) parseConditional(cond *Node) (*Node, error) {
+	question := p.next()
+	then, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.Kind != TokenColon {
+		if tok.Kind == TokenEOF {
+			return nil, unexpected(question, "missing \":\" of conditional")
+		}
+		return nil, unexpected(tok, fmt.Sprintf("unexpected %s, expected \":\"", tok))
+	}
+	p.next()
+	otherwise, err := p.parseExpression(conditionalPrecedence)
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Kind: FunctionNode, Operator: ConditionalOperator, Args: []*Node{cond, then, otherwise}, Column: question.Column}, nil
+}
+
+// Вызов функции: name(arg, ...)
+func (p *parser) parseCall(name Token) (*Node, error) {
+	f, ok := functions[name.Text]
+	if !ok {
+		return nil, unexpected(name, fmt.Sprintf("unknown function %q", name.Text))
+	}
+	if p.peek().Kind != TokenLParen {
+		return nil, unexpected(p.peek(), fmt.Sprintf("unexpected %s, expected \"(\" after function %q", p.peek(), name.Text))
+	}
+	p.next()
+	node := &Node{Kind: FunctionNode, Operator: name.Text, Args: []*Node{}, Column: name.Column}
+	if p.peek().Kind != TokenRParen {
+		for {
+			arg, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			node.Args = append(node.Args, arg)
+			if p.peek().Kind != TokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	switch tok := p.peek(); tok.Kind {
+	case TokenRParen:
+		p.next()
+	case TokenEOF:
+		return nil, unexpected(name, fmt.Sprintf("unclosed call of function %q", name.Text))
+	default:
+		return nil, unexpected(tok, fmt.Sprintf("unexpected %s, expected \",\" or \")\"", tok))
+	}
+	if len(node.Args) < f.minArgs || (f.maxArgs >= 0 && len(node.Args) > f.maxArgs) {
+		return nil, unexpected(name, fmt.Sprintf("function %q expects %s, got %d", name.Text, f.arityString(), len(node.Args)))
+	}
+	return node, nil
+}
+
+// Проверяет, что для всех переменных выражения заданы значения
+func checkBindings(node *Node, variables map[string]float64) error {
+	if node.Kind == VariableNode {
+		if _, ok := variables[node.Text]; !ok {
+			return &SyntaxError{Message: fmt.Sprintf("unbound variable %q", node.Text), Column: node.Column, Token: node.Text}
+		}
+	}
+	for _, arg := range node.Args {
+		if err := checkBindings(arg, variables); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package calc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseUnaryPlus(t *testing.T) {
+	tests := []struct {
+		expression string
+		ok         bool
+	}{
+		{"+2", true},
+		{"2*+4", true},
+		{"2^+2", true},
+		{"-+2", true},
+		{"2*(+4)", true},
+		{"2++3", false},
+		{"2-+3", false},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.expression)
+		if test.ok && err != nil {
+			t.Errorf("%q: unexpected error %v", test.expression, err)
+		}
+		if !test.ok {
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+			}
+		}
+	}
+}
+
+func TestTokenizeNonASCIIAfterNumber(t *testing.T) {
+	tests := []struct {
+		expression string
+		token      string
+		column     int
+	}{
+		{"2×3", "×", 2},
+		{"2é", "é", 2},
+		{"1.5ü", "ü", 4},
+		{"2x", "2x", 1},
+	}
+	for _, test := range tests {
+		_, err := Tokenize(test.expression)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: expected a syntax error, got %v", test.expression, err)
+			continue
+		}
+		if syntaxErr.Token != test.token || syntaxErr.Column != test.column {
+			t.Errorf("%q: error at %q, column %d, want %q, column %d", test.expression, syntaxErr.Token, syntaxErr.Column, test.token, test.column)
+		}
+	}
+}
@@ -41,130 +41,213 @@ func CloseConnection(c *Connection) {
 	defer c.conn.Close()
 }
 
-func (c *Connection) InsertExpression(ctx context.Context, id, expr string) error {
-	query := `INSERT INTO expressions(expressionid, expression, status, userid) VALUES (@expressionId, @expression, @status, @userid) returning expressionid`
-	args := pgx.NamedArgs{
-		"expressionId": id,
-		"expression":   expr,
-		"status":       0,
+// Выражение пользователя
+type Expression struct {
+	Uuid   string           `json:"expressionid"`
+	Expr   string           `json:"expression"`
+	Status ExpressionStatus `json:"status"`
+	// Название состояния (pending, planned, running, succeeded, failed, cancelled)
+	State     string             `json:"state"`
+	Result    interface{}        `json:"result"`
+	Variables map[string]float64 `json:"variables,omitempty"`
+	Mode      string             `json:"mode"`
+	// Точный результат в виде дроби (только для режима exact)
+	ExactResult *string `json:"exactResult,omitempty"`
+	// Перестраивать ли цепочки + и * в сбалансированные деревья
+	Balance bool `json:"balance"`
+	// Формат, в котором выражение было отправлено (infix, rpn, prefix, latex).
+	// Само выражение хранится в канонической инфиксной записи.
+	Format string `json:"format"`
+	// Причина ошибки или отмены вычисления (только для статусов -1 и -2)
+	Error *ExpressionError `json:"error,omitempty"`
+	// Задание, для которого оркестратор создал выражение
+	JobID *string `json:"jobid,omitempty"`
+}
+
+// Причина, по которой выражение завершилось ошибкой
+type ExpressionError struct {
+	// Код ошибки (calc.Code...)
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Операция, при выполнении которой произошла ошибка (нет для ошибок разбиения выражения)
+	OperationID *string `json:"operationid,omitempty"`
+}
+
+// Собирает ExpressionError из столбцов error, errorcode, erroroperation
+func expressionError(message, code, operationid *string) *ExpressionError {
+	if message == nil {
+		return nil
+	}
+	res := &ExpressionError{Message: *message, OperationID: operationid}
+	if code != nil {
+		res.Code = *code
+	}
+	return res
+}
+
+const insertExpressionQuery = `INSERT INTO expressions(expressionid, expression, status, userid, variables, mode, balance, format, jobid) VALUES (@expressionId, @expression, @status, @userid, @variables, @mode, @balance, @format, @jobid) returning expressionid`
+
+// Параметры insertExpressionQuery: выражение создаётся от имени пользователя из ctx
+func insertExpressionArgs(ctx context.Context, expr Expression) pgx.NamedArgs {
+	return pgx.NamedArgs{
+		"expressionId": expr.Uuid,
+		"expression":   expr.Expr,
+		"status":       int(ExpressionPending),
 		"userid":       ctx.Value("userid"),
+		"variables":    expr.Variables,
+		"mode":         expr.Mode,
+		"balance":      expr.Balance,
+		"format":       expr.Format,
+		"jobid":        expr.JobID,
 	}
-	_, err := c.conn.Exec(ctx, query, args)
+}
+
+func (c *Connection) InsertExpression(ctx context.Context, expr Expression) error {
+	_, err := c.conn.Exec(ctx, insertExpressionQuery, insertExpressionArgs(ctx, expr))
 	if err != nil {
 		return fmt.Errorf("unable to insert row: %w", err)
 	}
 	return nil
 }
 
-func (c *Connection) GetExpressions(ctx context.Context) ([]struct {
-	Uuid   string      `json:"expressionid"`
-	Expr   string      `json:"expression"`
-	Status int         `json:"status"`
-	Result interface{} `json:"result"`
-}, error) {
-	query := `SELECT expressionid, expression, status, result FROM expressions where userid = $1`
+// Выражения пользователя без выражений заданий
+func (c *Connection) GetExpressions(ctx context.Context) ([]Expression, error) {
+	query := `SELECT expressionid, expression, status, result, variables, mode, exactresult, balance, format, error, errorcode, erroroperation FROM expressions where userid = $1 and jobid is null`
 	rows, err := c.conn.Query(ctx, query, ctx.Value("userid"))
 	if err != nil {
-		return []struct {
-			Uuid   string      `json:"expressionid"`
-			Expr   string      `json:"expression"`
-			Status int         `json:"status"`
-			Result interface{} `json:"result"`
-		}{}, fmt.Errorf("unable to query expressions: %w", err)
+		return []Expression{}, fmt.Errorf("unable to query expressions: %w", err)
 	}
 	defer rows.Close()
-	exprs := []struct {
-		Uuid   string      `json:"expressionid"`
-		Expr   string      `json:"expression"`
-		Status int         `json:"status"`
-		Result interface{} `json:"result"`
-	}{}
+	exprs := []Expression{}
 	for rows.Next() {
-		expr := struct {
-			Uuid   string      `json:"expressionid"`
-			Expr   string      `json:"expression"`
-			Status int         `json:"status"`
-			Result interface{} `json:"result"`
-		}{}
-		err := rows.Scan(&expr.Uuid, &expr.Expr, &expr.Status, &expr.Result)
+		expr := Expression{}
+		var status int
+		var errMessage, errCode, errOperation *string
+		err := rows.Scan(&expr.Uuid, &expr.Expr, &status, &expr.Result, &expr.Variables, &expr.Mode, &expr.ExactResult, &expr.Balance, &expr.Format, &errMessage, &errCode, &errOperation)
 		if err != nil {
-			return []struct {
-				Uuid   string      `json:"expressionid"`
-				Expr   string      `json:"expression"`
-				Status int         `json:"status"`
-				Result interface{} `json:"result"`
-			}{}, fmt.Errorf("unable to scan row: %w", err)
+			return []Expression{}, fmt.Errorf("unable to scan row: %w", err)
 		}
+		expr.Status = ExpressionStatus(status)
+		expr.State = expr.Status.String()
+		expr.Error = expressionError(errMessage, errCode, errOperation)
 		exprs = append(exprs, expr)
 	}
 
 	return exprs, nil
 }
 
-func (c *Connection) GetExpressionByID(ctx context.Context, expressionid string) (string, interface{}, int32, error) {
+func (c *Connection) GetExpressionByID(ctx context.Context, expressionid string) (Expression, error) {
 	// ctxWithT, cancel := context.WithTimeout(ctx, time.Second*2)
 	// defer cancel()
-	query := `SELECT expression, result, status FROM expressions where expressionid = @expressionId and userid = @userid`
+	query := `SELECT expressionid, expression, result, status, variables, mode, exactresult, balance, format, error, errorcode, erroroperation, jobid FROM expressions where expressionid = @expressionId and userid = @userid`
 	args := pgx.NamedArgs{
 		"expressionId": expressionid,
 		"userid":       ctx.Value("userid"),
 	}
 	rows, err := c.conn.Query(ctx, query, args)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("unable to query expression: %w", err)
+		return Expression{}, fmt.Errorf("unable to query expression: %w", err)
 	}
 	defer rows.Close()
-	var expression string
-	var result interface{}
-	var status interface{}
+	var expr Expression
+	var status *int
+	var errMessage, errCode, errOperation *string
 	for rows.Next() {
-		err := rows.Scan(&expression, &result, &status)
+		err := rows.Scan(&expr.Uuid, &expr.Expr, &expr.Result, &status, &expr.Variables, &expr.Mode, &expr.ExactResult, &expr.Balance, &expr.Format, &errMessage, &errCode, &errOperation, &expr.JobID)
 		if err != nil {
-			return "", "", 0, fmt.Errorf("unable to scan row: %w", err)
+			return Expression{}, fmt.Errorf("unable to scan row: %w", err)
 		}
 	}
+	expr.Error = expressionError(errMessage, errCode, errOperation)
 	if status == nil {
-		return "", "", 0, fmt.Errorf("expression didn't exist")
+		return Expression{}, fmt.Errorf("expression didn't exist")
 	}
-	st, _ := status.(int32)
-	return expression, result, st, nil
+	expr.Status = ExpressionStatus(*status)
+	expr.State = expr.Status.String()
+	return expr, nil
+}
+
+// Выражение, ожидающее разбиения на операции
+type ExpressionToPlan struct {
+	ExpressionID string
+	Expression   string
+	Variables    map[string]float64
+	Mode         string
+	Balance      bool
 }
 
-func (c *Connection) GetNotPartitionExpressions(ctx context.Context) ([][]string, error) {
+func (c *Connection) GetNotPartitionExpressions(ctx context.Context) ([]ExpressionToPlan, error) {
 	// ctxWithT, cancel := context.WithTimeout(ctx, time.Second*2)
 	// defer cancel()
-	query := `SELECT expressionid, expression FROM expressions where status = 0`
-	rows, err := c.conn.Query(ctx, query)
+	query := `SELECT expressionid, expression, variables, mode, balance FROM expressions where status = @status`
+	rows, err := c.conn.Query(ctx, query, pgx.NamedArgs{"status": int(ExpressionPending)})
 	if err != nil {
-		return [][]string{}, fmt.Errorf("unable to query expressions: %w", err)
+		return []ExpressionToPlan{}, fmt.Errorf("unable to query expressions: %w", err)
 	}
 	defer rows.Close()
-	var result [][]string
+	var result []ExpressionToPlan
 	for rows.Next() {
-		var res = make([]string, 2)
-		err := rows.Scan(&res[0], &res[1])
+		var res ExpressionToPlan
+		err := rows.Scan(&res.ExpressionID, &res.Expression, &res.Variables, &res.Mode, &res.Balance)
 		if err != nil {
-			return [][]string{}, fmt.Errorf("unable to scan row: %w", err)
+			return []ExpressionToPlan{}, fmt.Errorf("unable to scan row: %w", err)
 		}
 		result = append(result, res)
 	}
 	return result, nil
 }
 
+// Операнды в виде массива записей calc.FormatValue, где NULL - ещё не вычисленное значение
+func argsToArray(args []interface{}) []*string {
+	res := make([]*string, len(args))
+	for i, arg := range args {
+		if arg != nil {
+			v := calc.FormatValue(arg)
+			res[i] = &v
+		}
+	}
+	return res
+}
+
+func arrayToArgs(mode string, arr []*string) ([]interface{}, error) {
+	res := make([]interface{}, len(arr))
+	for i, v := range arr {
+		if v == nil {
+			continue
+		}
+		arg, err := calc.ParseValue(mode, *v)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = arg
+	}
+	return res, nil
+}
+
 func (c *Connection) BulkInsertOperations(ctx context.Context, tasks []calc.Operation) error {
-	query := `INSERT INTO operations (operationid, operator, v1, v2, expressionid, parentid, "left", status) VALUES (@operationid, @operator, @v1, @v2, @expressionid, @parentid, @left,@status)`
+	query := `INSERT INTO operations (operationid, operator, args, expressionid, parentids, positions, guard, branch, status, mode) VALUES (@operationid, @operator, @args, @expressionid, @parentids, @positions, @guard, @branch, @status, @mode)`
 
 	batch := &pgx.Batch{}
 	for _, task := range tasks {
+		parentids, positions := parentsToArrays(task.Parents)
+		// Операции ветвей условных операций спят до выбора ветви
+		status := OperationWaiting
+		var guard *string
+		if task.Guard != "" {
+			status = OperationDormant
+			guard = &task.Guard
+		}
 		args := pgx.NamedArgs{
 			"expressionid": task.ExpressionID,
 			"operator":     task.Operator,
-			"v1":           task.V1,
-			"v2":           task.V2,
+			"args":         argsToArray(task.Args),
 			"operationid":  task.OperationID,
-			"parentid":     task.ParentID,
-			"left":         task.Left,
-			"status":       task.Status,
+			"parentids":    parentids,
+			"positions":    positions,
+			"guard":        guard,
+			"branch":       task.Branch,
+			"status":       int(status),
+			"mode":         task.Mode,
 		}
 		batch.Queue(query, args)
 	}
@@ -178,45 +261,143 @@ func (c *Connection) BulkInsertOperations(ctx context.Context, tasks []calc.Oper
 				slog.Info(fmt.Sprintf("operation %s already exists", task.OperationID))
 				continue
 			}
-			slog.Info(fmt.Sprint(task.ExpressionID, task.OperationID, task.ParentID))
+			slog.Info(fmt.Sprint(task.ExpressionID, task.OperationID, task.Parents))
 			return fmt.Errorf("unable to insert row: %w", err)
 		}
 	}
 	return results.Close()
 }
 
-func (c *Connection) ChangeOperationStatus(ctx context.Context, operationid string, status int) error {
-	query := `UPDATE operations SET status = @status, changedtime = @time WHERE operationid = @operationid`
+// Родители операции в виде двух массивов одинаковой длины: UUID родителей и номера операндов
+func parentsToArrays(parents []calc.Parent) ([]string, []int) {
+	parentids := make([]string, len(parents))
+	positions := make([]int, len(parents))
+	for i, parent := range parents {
+		parentids[i] = parent.OperationID
+		positions[i] = parent.Position
+	}
+	return parentids, positions
+}
+
+func arraysToParents(parentids []string, positions []int) ([]calc.Parent, error) {
+	if len(parentids) != len(positions) {
+		return nil, fmt.Errorf("parentids and positions have different lengths")
+	}
+	res := make([]calc.Parent, len(parentids))
+	for i := range parentids {
+		res[i] = calc.Parent{OperationID: parentids[i], Position: positions[i]}
+	}
+	return res, nil
+}
+
+// Меняет состояние операции. Если переход из текущего состояния не допускается, возвращается ErrStatusTransition.
+func (c *Connection) ChangeOperationStatus(ctx context.Context, operationid string, status OperationStatus) error {
+	query := `UPDATE operations SET status = @status, changedtime = @time WHERE operationid = @operationid and status = any(@from)`
 	args := pgx.NamedArgs{
 		"operationid": operationid,
-		"status":      status,
+		"status":      int(status),
+		"from":        operationSources(status),
 		"time":        time.Now(),
 	}
-	_, err := c.conn.Exec(ctx, query, args)
+	tag, err := c.conn.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to update row: %w", err)
 	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("operation %s to %d: %w", operationid, status, ErrStatusTransition)
+	}
 	slog.Info(fmt.Sprintf("Changed operation %s status to %d", operationid, status))
 	return nil
 }
 
-func (c *Connection) ChangeExpressionStatus(ctx context.Context, expressionid string, status int) error {
-	query := `UPDATE expressions SET status = @status WHERE expressions.expressionid = @expressionId`
+// Меняет состояние выражения. Если переход из текущего состояния не допускается, возвращается ErrStatusTransition.
+func (c *Connection) ChangeExpressionStatus(ctx context.Context, expressionid string, status ExpressionStatus) error {
+	query := `UPDATE expressions SET status = @status WHERE expressions.expressionid = @expressionId and status = any(@from)`
+	args := pgx.NamedArgs{
+		"expressionId": expressionid,
+		"status":       int(status),
+		"from":         expressionSources(status),
+	}
+	tag, err := c.conn.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("expression %s to %s: %w", expressionid, status, ErrStatusTransition)
+	}
+	slog.Info(fmt.Sprintf("Changed expression %s status to %s", expressionid, status))
+	return nil
+}
+
+// Отменяет незавершённое выражение пользователя. Операции отменённого выражения больше не отправляются агентам,
+// а результаты уже отправленных не сохраняются. Причина сохраняется с кодом calc.CodeCancelled.
+// Для завершённого выражения возвращается ErrStatusTransition.
+func (c *Connection) CancelExpression(ctx context.Context, expressionid string) error {
+	query := `UPDATE expressions SET status = @status, error = @error, errorcode = @errorcode, erroroperation = null WHERE expressionid = @expressionId and userid = @userid and status = any(@from)`
 	args := pgx.NamedArgs{
 		"expressionId": expressionid,
-		"status":       status,
+		"userid":       ctx.Value("userid"),
+		"status":       int(ExpressionCancelled),
+		"from":         expressionSources(ExpressionCancelled),
+		"error":        "cancelled by user",
+		"errorcode":    calc.CodeCancelled,
+	}
+	tag, err := c.conn.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("expression %s to %s: %w", expressionid, ExpressionCancelled, ErrStatusTransition)
+	}
+	slog.Info(fmt.Sprintf("Expression %s cancelled", expressionid))
+	return nil
+}
+
+// Отмечает выражения, операции которых отправлены агентам. Выражения не в состоянии planned не меняются.
+func (c *Connection) SetExpressionsRunning(ctx context.Context, expressionids []string) error {
+	query := `UPDATE expressions SET status = @status WHERE expressionid = any(@ids) and status = any(@from)`
+	args := pgx.NamedArgs{
+		"ids":    expressionids,
+		"status": int(ExpressionRunning),
+		"from":   expressionSources(ExpressionRunning),
 	}
 	_, err := c.conn.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to update row: %w", err)
 	}
-	slog.Info(fmt.Sprintf("Changed expression %s status to %d", expressionid, status))
 	return nil
 }
 
+// Завершает выражение ошибкой: состояние failed и причина, которую видит пользователь
+func (c *Connection) SetExpressionFailed(ctx context.Context, expressionid string, reason ExpressionError) error {
+	query := `UPDATE expressions SET status = @status, error = @error, errorcode = @errorcode, erroroperation = @erroroperation WHERE expressionid = @expressionId and status = any(@from)`
+	args := pgx.NamedArgs{
+		"expressionId":   expressionid,
+		"status":         int(ExpressionFailed),
+		"from":           expressionSources(ExpressionFailed),
+		"error":          reason.Message,
+		"errorcode":      reason.Code,
+		"erroroperation": reason.OperationID,
+	}
+	tag, err := c.conn.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("expression %s to %s: %w", expressionid, ExpressionFailed, ErrStatusTransition)
+	}
+	slog.Info(fmt.Sprintf("Expression %s failed: %s (%s)", expressionid, reason.Message, reason.Code))
+	return nil
+}
+
+// Операции с вычисленными операндами. Отправляются только операции выражений в состояниях planned и running.
 func (c *Connection) GetOperationsToExecution(ctx context.Context) ([]calc.Operation, error) {
-	query := `SELECT operationid, operator, v1, v2, expressionid, parentid, "left" FROM operations where v1 IS NOT NULL and v2 is not null and status = 0`
-	rows, err := c.conn.Query(ctx, query)
+	query := `SELECT o.operationid, o.operator, o.args, o.expressionid, o.mode FROM operations o join expressions e on e.expressionid = o.expressionid where array_position(o.args, NULL) is null and o.status = @status and e.status = any(@active)`
+	args := pgx.NamedArgs{
+		"status": int(OperationWaiting),
+		"active": activeExpressionStatuses,
+	}
+	rows, err := c.conn.Query(ctx, query, args)
 	if err != nil {
 		return []calc.Operation{}, fmt.Errorf("unable to query operations: %w", err)
 	}
@@ -224,7 +405,43 @@ func (c *Connection) GetOperationsToExecution(ctx context.Context) ([]calc.Opera
 	result := []calc.Operation{}
 	for rows.Next() {
 		var res = calc.Operation{}
-		err := rows.Scan(&res.OperationID, &res.Operator, &res.V1, &res.V2, &res.ExpressionID, &res.ParentID, &res.Left)
+		var args []*string
+		err := rows.Scan(&res.OperationID, &res.Operator, &args, &res.ExpressionID, &res.Mode)
+		if err != nil {
+			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		res.Args, err = arrayToArgs(res.Mode, args)
+		if err != nil {
+			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		result = append(result, res)
+	}
+	return result, nil
+}
+
+// Условные операции, для которых известно условие, но ещё не выбрана ветвь.
+// Возвращаются только операции выражений в состояниях planned и running.
+func (c *Connection) GetConditionsToResolve(ctx context.Context) ([]calc.Operation, error) {
+	query := `SELECT o.operationid, o.args, o.expressionid, o.mode FROM operations o join expressions e on e.expressionid = o.expressionid where o.operator = @operator and o.status = @status and o.args[1] is not null and (o.args[2] is null or o.args[3] is null) and o.chosenbranch is null and e.status = any(@active)`
+	args := pgx.NamedArgs{
+		"operator": calc.ConditionalOperator,
+		"status":   int(OperationWaiting),
+		"active":   activeExpressionStatuses,
+	}
+	rows, err := c.conn.Query(ctx, query, args)
+	if err != nil {
+		return []calc.Operation{}, fmt.Errorf("unable to query operations: %w", err)
+	}
+	defer rows.Close()
+	result := []calc.Operation{}
+	for rows.Next() {
+		var res = calc.Operation{}
+		var args []*string
+		err := rows.Scan(&res.OperationID, &args, &res.ExpressionID, &res.Mode)
+		if err != nil {
+			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		res.Args, err = arrayToArgs(res.Mode, args)
 		if err != nil {
 			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
 		}
@@ -233,14 +450,121 @@ func (c *Connection) GetOperationsToExecution(ctx context.Context) ([]calc.Opera
 	return result, nil
 }
 
-func (c *Connection) BulkChangeStatusOperations(ctx context.Context, status int, operations []calc.Operation) error {
+// Выбирает ветвь branch (1 - then, 2 - else) условной операции: операции ветви просыпаются,
+// а операнд другой ветви заполняется нулём, чтобы условная операция могла быть отправлена агенту.
+// Выбранная ветвь записывается в chosenbranch, и GetConditionsToResolve больше не возвращает операцию.
+// Повторный вызов ничего не меняет.
+func (c *Connection) ResolveCondition(ctx context.Context, operationid string, branch int) error {
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE operations SET status = @status, changedtime = @time where guard = @operationid and branch = @branch and status = any(@from)`, pgx.NamedArgs{
+		"operationid": operationid,
+		"branch":      branch,
+		"status":      int(OperationWaiting),
+		"from":        []int{int(OperationDormant)},
+		"time":        time.Now(),
+	})
+	// Индексы массивов в PostgreSQL начинаются с 1, условие - первый операнд
+	batch.Queue(`UPDATE operations SET args[@position] = @value where operationid = @operationid and args[@position] is null`, pgx.NamedArgs{
+		"operationid": operationid,
+		"position":    3 - branch + 1,
+		"value":       "0",
+	})
+	batch.Queue(`UPDATE operations SET chosenbranch = @branch where operationid = @operationid and chosenbranch is null`, pgx.NamedArgs{
+		"operationid": operationid,
+		"branch":      branch,
+	})
+	results := c.conn.SendBatch(ctx, batch)
+	defer results.Close()
+	for i := 0; i < batch.Len(); i++ {
+		_, err := results.Exec()
+		if err != nil {
+			return fmt.Errorf("unable to update row: %w", err)
+		}
+	}
+	return results.Close()
+}
+
+// Операция выражения с её состоянием
+type ExpressionOperation struct {
+	calc.Operation
+	// Время последней смены состояния (Operation.Status - код OperationStatus)
+	ChangedTime *time.Time
+	// Текст ошибки выполнения
+	Error *string
+	// Время постановки в очередь агентов
+	QueuedTime *time.Time
+	// Агент, выполнивший операцию, и время выполнения на нём
+	Worker       *string
+	StartedTime  *time.Time
+	FinishedTime *time.Time
+}
+
+// Все операции выражения пользователя
+func (c *Connection) GetExpressionOperations(ctx context.Context, expressionid string) ([]ExpressionOperation, error) {
+	query := `SELECT o.operationid, o.operator, o.args, o.expressionid, o.parentids, o.positions, o.guard, o.branch, o.status, o.result, o.mode, o.changedtime, o.error, o.queuedtime, o.worker, o.startedtime, o.finishedtime FROM operations o join expressions e on e.expressionid = o.expressionid where o.expressionid = @expressionid and e.userid = @userid order by o.startedtime nulls last, o.operationid`
+	args := pgx.NamedArgs{
+		"expressionid": expressionid,
+		"userid":       ctx.Value("userid"),
+	}
+	rows, err := c.conn.Query(ctx, query, args)
+	if err != nil {
+		return []ExpressionOperation{}, fmt.Errorf("unable to query operations: %w", err)
+	}
+	defer rows.Close()
+	result := []ExpressionOperation{}
+	for rows.Next() {
+		var res = ExpressionOperation{}
+		var args []*string
+		var parentids []string
+		var positions []int
+		var guard, value *string
+		var branch *int
+		var status int
+		err := rows.Scan(&res.OperationID, &res.Operator, &args, &res.ExpressionID, &parentids, &positions, &guard, &branch, &status, &value, &res.Mode, &res.ChangedTime, &res.Error, &res.QueuedTime, &res.Worker, &res.StartedTime, &res.FinishedTime)
+		if err != nil {
+			return []ExpressionOperation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		res.Args, err = arrayToArgs(res.Mode, args)
+		if err != nil {
+			return []ExpressionOperation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		res.Parents, err = arraysToParents(parentids, positions)
+		if err != nil {
+			return []ExpressionOperation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		if guard != nil {
+			res.Guard = *guard
+		}
+		if branch != nil {
+			res.Branch = *branch
+		}
+		if value != nil {
+			res.Result, err = calc.ParseValue(res.Mode, *value)
+			if err != nil {
+				return []ExpressionOperation{}, fmt.Errorf("unable to scan row: %w", err)
+			}
+		}
+		res.Status = status
+		result = append(result, res)
+	}
+	return result, nil
+}
+
+// Меняет состояние операций. Операции, для которых переход не допускается, пропускаются с предупреждением.
+func (c *Connection) BulkChangeStatusOperations(ctx context.Context, status OperationStatus, operations []calc.Operation) error {
 	now := time.Now()
-	query := `UPDATE operations SET status = @status, changedtime = @time where operationid = @operationid`
+	query := `UPDATE operations SET status = @status, changedtime = @time where operationid = @operationid and status = any(@from)`
+	if status == OperationSent {
+		// Время постановки операции в очередь агентов
+		query = `UPDATE operations SET status = @status, changedtime = @time, queuedtime = @time where operationid = @operationid and status = any(@from)`
+	}
+	from := operationSources(status)
 	batch := &pgx.Batch{}
 	for _, task := range operations {
 		args := pgx.NamedArgs{
 			"operationid": task.OperationID,
-			"status":      status,
+			"status":      int(status),
+			"from":        from,
 			"time":        now,
 		}
 		batch.Queue(query, args)
@@ -248,46 +572,126 @@ func (c *Connection) BulkChangeStatusOperations(ctx context.Context, status int,
 	results := c.conn.SendBatch(ctx, batch)
 	defer results.Close()
 	for _, task := range operations {
-		_, err := results.Exec()
+		tag, err := results.Exec()
 		if err != nil {
-			slog.Info(fmt.Sprint(task.ExpressionID, task.OperationID, task.ParentID))
+			slog.Info(fmt.Sprint(task.ExpressionID, task.OperationID))
 			return fmt.Errorf("unable to insert row: %w", err)
 		}
+		if tag.RowsAffected() == 0 {
+			slog.Warn(fmt.Sprintf("operation %s to %d: %s", task.OperationID, status, ErrStatusTransition))
+		}
 	}
 	return results.Close()
 }
 
-func (c *Connection) SetOperationResult(ctx context.Context, operationid string, result float64) error {
-	query := `UPDATE operations SET result = @result where operationid = @operationid`
-	args := pgx.NamedArgs{
+// Сведения агента о выполнении операции
+type OperationExecution struct {
+	Worker     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Аргументы запроса для сохранения сведений о выполнении (пустые значения - NULL).
+// Столбцы времени хранят часовой пояс, поэтому время агента сохраняется как есть.
+func (e OperationExecution) namedArgs(args pgx.NamedArgs) pgx.NamedArgs {
+	args["worker"], args["startedtime"], args["finishedtime"] = nil, nil, nil
+	if e.Worker != "" {
+		args["worker"] = e.Worker
+	}
+	if !e.StartedAt.IsZero() {
+		args["startedtime"] = e.StartedAt
+	}
+	if !e.FinishedAt.IsZero() {
+		args["finishedtime"] = e.FinishedAt
+	}
+	return args
+}
+
+// Сохраняет результат операции. Результат операции завершённого (например, отменённого) выражения
+// не сохраняется: операция сразу помечается выполненной, чтобы её не подбирали повторно.
+func (c *Connection) SetOperationResult(ctx context.Context, operationid string, result interface{}, execution OperationExecution) error {
+	query := `UPDATE operations o SET result = @result, worker = @worker, startedtime = @startedtime, finishedtime = @finishedtime from expressions e where e.expressionid = o.expressionid and o.operationid = @operationid and e.status = any(@active)`
+	args := execution.namedArgs(pgx.NamedArgs{
 		"operationid": operationid,
-		"result":      result,
+		"result":      calc.FormatValue(result),
+		"active":      activeExpressionStatuses,
+	})
+	tag, err := c.conn.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return c.dropOperationResult(ctx, operationid, execution)
 	}
+	slog.Info(fmt.Sprintf("Get operation (%s) result: %s", operationid, calc.FormatValue(result)))
+	return nil
+}
+
+// Завершает операцию выражения, которое больше не вычисляется, сохраняя только сведения о выполнении
+func (c *Connection) dropOperationResult(ctx context.Context, operationid string, execution OperationExecution) error {
+	query := `UPDATE operations SET status = @status, changedtime = @time, worker = @worker, startedtime = @startedtime, finishedtime = @finishedtime where operationid = @operationid and status = any(@from)`
+	args := execution.namedArgs(pgx.NamedArgs{
+		"operationid": operationid,
+		"status":      int(OperationDone),
+		"from":        operationSources(OperationDone),
+		"time":        time.Now(),
+	})
 	_, err := c.conn.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to update row: %w", err)
 	}
-	slog.Info(fmt.Sprintf("Get operation (%s) result: %f", operationid, result))
+	slog.Info(fmt.Sprintf("Dropped result of operation %s: expression is finished", operationid))
 	return nil
 }
 
-func (c *Connection) SetExpressionResult(ctx context.Context, expressionid string, result float64) error {
-	query := `UPDATE expressions SET result = @result where expressionid = @expressionid`
+// Помечает операцию как завершившуюся ошибкой и сохраняет её текст. Возвращает id выражения операции.
+func (c *Connection) SetOperationFailed(ctx context.Context, operationid string, message string, execution OperationExecution) (string, error) {
+	query := `UPDATE operations SET status = @status, error = @error, changedtime = @time, worker = @worker, startedtime = @startedtime, finishedtime = @finishedtime where operationid = @operationid and status = any(@from) returning expressionid`
+	args := execution.namedArgs(pgx.NamedArgs{
+		"operationid": operationid,
+		"status":      int(OperationFailed),
+		"from":        operationSources(OperationFailed),
+		"error":       message,
+		"time":        time.Now(),
+	})
+	var expressionid string
+	err := c.conn.QueryRow(ctx, query, args).Scan(&expressionid)
+	if err != nil {
+		return "", fmt.Errorf("unable to update row: %w", err)
+	}
+	return expressionid, nil
+}
+
+// Сохраняет результат выражения и переводит его в состояние succeeded.
+// Для точного режима result - дробь, в result записывается её приближённое значение.
+func (c *Connection) SetExpressionResult(ctx context.Context, expressionid string, result interface{}) error {
+	query := `UPDATE expressions SET result = @result, exactresult = @exactresult, status = @status where expressionid = @expressionid and status = any(@from)`
+	var exactResult *string
+	if v, ok := result.(string); ok {
+		exactResult = &v
+	}
 	args := pgx.NamedArgs{
 		"expressionid": expressionid,
-		"result":       result,
+		"result":       calc.ApproximateValue(result),
+		"exactresult":  exactResult,
+		"status":       int(ExpressionSucceeded),
+		"from":         expressionSources(ExpressionSucceeded),
 	}
-	_, err := c.conn.Exec(ctx, query, args)
+	tag, err := c.conn.Exec(ctx, query, args)
 	if err != nil {
 		return fmt.Errorf("unable to update row: %w", err)
 	}
-	slog.Info(fmt.Sprintf("Get expression (%s) result: %f", expressionid, result))
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("expression %s to %s: %w", expressionid, ExpressionSucceeded, ErrStatusTransition)
+	}
+	slog.Info(fmt.Sprintf("Get expression (%s) result: %s", expressionid, calc.FormatValue(result)))
 	return nil
 }
 
+// Операции с полученным результатом, который ещё не передан родителям. Операции завершённых выражений не возвращаются.
 func (c *Connection) GetComplitedOperation(ctx context.Context) ([]calc.Operation, error) {
-	query := `SELECT operationid, expressionid, parentid, "left", result FROM operations where status = 1 and result is not null`
-	rows, err := c.conn.Query(ctx, query)
+	query := `SELECT o.operationid, o.expressionid, o.parentids, o.positions, o.result, o.mode FROM operations o join expressions e on e.expressionid = o.expressionid where o.status = @status and o.result is not null and e.status = any(@active)`
+	rows, err := c.conn.Query(ctx, query, pgx.NamedArgs{"status": int(OperationSent), "active": activeExpressionStatuses})
 	if err != nil {
 		return []calc.Operation{}, fmt.Errorf("unable to query operations: %w", err)
 	}
@@ -295,7 +699,18 @@ func (c *Connection) GetComplitedOperation(ctx context.Context) ([]calc.Operatio
 	result := []calc.Operation{}
 	for rows.Next() {
 		var res = calc.Operation{}
-		err := rows.Scan(&res.OperationID, &res.ExpressionID, &res.ParentID, &res.Left, &res.Result)
+		var value string
+		var parentids []string
+		var positions []int
+		err := rows.Scan(&res.OperationID, &res.ExpressionID, &parentids, &positions, &value, &res.Mode)
+		if err != nil {
+			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		res.Parents, err = arraysToParents(parentids, positions)
+		if err != nil {
+			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		res.Result, err = calc.ParseValue(res.Mode, value)
 		if err != nil {
 			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
 		}
@@ -307,21 +722,18 @@ func (c *Connection) GetComplitedOperation(ctx context.Context) ([]calc.Operatio
 func (c *Connection) SetOperationResultToParent(ctx context.Context, opers []struct {
 	Operationid string
 	Parentid    string
-	Res         float64
-	Left        bool
+	Res         interface{}
+	Position    int
 }) error {
 	batch := &pgx.Batch{}
-	var query string
+	// Индексы массивов в PostgreSQL начинаются с 1
+	query := `UPDATE operations SET args[@position] = @result where operationid = @parentid`
 	for _, op := range opers {
-		if op.Left {
-			query = `UPDATE operations SET v1 = @result where operationid = @parentid`
-		} else {
-			query = `UPDATE operations SET v2 = @result where operationid = @parentid`
-		}
 		args := pgx.NamedArgs{
 			"operationid": op.Operationid,
-			"result":      op.Res,
+			"result":      calc.FormatValue(op.Res),
 			"parentid":    op.Parentid,
+			"position":    op.Position + 1,
 		}
 		batch.Queue(query, args)
 	}
@@ -330,7 +742,7 @@ func (c *Connection) SetOperationResultToParent(ctx context.Context, opers []str
 	for _, op := range opers {
 		_, err := results.Exec()
 		if err != nil {
-			slog.Info(fmt.Sprint(op.Parentid, op.Left))
+			slog.Info(fmt.Sprint(op.Parentid, op.Position))
 			return fmt.Errorf("unable to update row: %w", err)
 		}
 		slog.Info(fmt.Sprintf("Update %s", op.Operationid))
@@ -403,11 +815,16 @@ func (c *Connection) GetUserID(ctx context.Context, username string) (int, error
 	return id, nil
 }
 
-func (c *Connection) UpdateStuckedOperations(ctx context.Context, timeout time.Duration) error {
-	query := `UPDATE operations set status=0 where (status = 1 and result is null and @time - changedtime > @delta) or (status = 2 and result is null)`
+// Возвращает в ожидание операции, которые агенты не вернули за timeout. Операции, уже отправленные
+// заново maxAttempts раз, не меняются: их завершает FailStuckedOperations.
+func (c *Connection) UpdateStuckedOperations(ctx context.Context, timeout time.Duration, maxAttempts int) error {
+	query := `UPDATE operations set status = @waiting, attempts = attempts + 1 where status = @sent and result is null and @time - changedtime > @delta and attempts < @max`
 	args := pgx.NamedArgs{
-		"time":  time.Now(),
-		"delta": timeout,
+		"waiting": int(OperationWaiting),
+		"sent":    int(OperationSent),
+		"time":    time.Now(),
+		"delta":   timeout,
+		"max":     maxAttempts,
 	}
 	_, err := c.conn.Exec(ctx, query, args)
 	if err != nil {
@@ -416,6 +833,35 @@ func (c *Connection) UpdateStuckedOperations(ctx context.Context, timeout time.D
 	return nil
 }
 
+// Завершает ошибкой операции, которые агенты не вернули за timeout и после maxAttempts повторных отправок.
+// Возвращает эти операции (заполнены OperationID и ExpressionID).
+func (c *Connection) FailStuckedOperations(ctx context.Context, timeout time.Duration, maxAttempts int, message string) ([]calc.Operation, error) {
+	query := `UPDATE operations set status = @status, error = @error, changedtime = @time where status = @sent and result is null and @time - changedtime > @delta and attempts >= @max returning operationid, expressionid`
+	args := pgx.NamedArgs{
+		"status": int(OperationFailed),
+		"sent":   int(OperationSent),
+		"error":  message,
+		"time":   time.Now(),
+		"delta":  timeout,
+		"max":    maxAttempts,
+	}
+	rows, err := c.conn.Query(ctx, query, args)
+	if err != nil {
+		return []calc.Operation{}, fmt.Errorf("unable to update rows: %w", err)
+	}
+	defer rows.Close()
+	result := []calc.Operation{}
+	for rows.Next() {
+		var res = calc.Operation{}
+		err := rows.Scan(&res.OperationID, &res.ExpressionID)
+		if err != nil {
+			return []calc.Operation{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		result = append(result, res)
+	}
+	return result, rows.Err()
+}
+
 func (c *Connection) GetUserIDFromOperationId(ctx context.Context, operationId string) (int, error) {
 	query := `SELECT e.userid
 	FROM public.operations o
@@ -0,0 +1,219 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Виды заданий
+const (
+	// Поиск корня f(x) = 0 на отрезке
+	JobRoot = "root"
+	// Вычисление выражения на диапазоне значений переменной
+	JobSweep = "sweep"
+)
+
+// Задание, которое вычисляется множеством выражений, построенных по одному шаблону.
+// Выражения задания создаёт оркестратор, их jobid указывает на задание.
+type Job struct {
+	JobID  string
+	Kind   string
+	UserID int
+	// Шаблон в канонической инфиксной записи и переменная, значения которой подставляет оркестратор
+	Expression string
+	Variable   string
+	// Значения остальных переменных шаблона
+	Variables map[string]float64
+	Balance   bool
+	// Параметры и ход вычисления задания, их содержимое зависит от вида задания
+	Params   json.RawMessage
+	Progress json.RawMessage
+	// Состояние задания: pending, running, succeeded или failed
+	Status      ExpressionStatus
+	Error       *ExpressionError
+	CreatedTime time.Time
+}
+
+// Незавершённые задания
+var activeJobStatuses = []int{int(ExpressionPending), int(ExpressionRunning)}
+
+func (c *Connection) InsertJob(ctx context.Context, job Job) error {
+	query := `INSERT INTO jobs(jobid, kind, userid, expression, variable, variables, balance, params, status, createdtime) VALUES (@jobid, @kind, @userid, @expression, @variable, @variables, @balance, @params, @status, @time)`
+	args := pgx.NamedArgs{
+		"jobid":      job.JobID,
+		"kind":       job.Kind,
+		"userid":     ctx.Value("userid"),
+		"expression": job.Expression,
+		"variable":   job.Variable,
+		"variables":  job.Variables,
+		"balance":    job.Balance,
+		"params":     job.Params,
+		"status":     int(ExpressionPending),
+		"time":       time.Now(),
+	}
+	_, err := c.conn.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to insert row: %w", err)
+	}
+	return nil
+}
+
+const jobColumns = `jobid, kind, userid, expression, variable, variables, balance, params, progress, status, error, errorcode, createdtime`
+
+func scanJob(rows pgx.Rows) (Job, error) {
+	job := Job{}
+	var status int
+	var errMessage, errCode *string
+	err := rows.Scan(&job.JobID, &job.Kind, &job.UserID, &job.Expression, &job.Variable, &job.Variables, &job.Balance, &job.Params, &job.Progress, &status, &errMessage, &errCode, &job.CreatedTime)
+	if err != nil {
+		return Job{}, fmt.Errorf("unable to scan row: %w", err)
+	}
+	job.Status = ExpressionStatus(status)
+	job.Error = expressionError(errMessage, errCode, nil)
+	return job, nil
+}
+
+// Задание пользователя вида kind
+func (c *Connection) GetJobByID(ctx context.Context, jobid string, kind string) (Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs where jobid = @jobid and kind = @kind and userid = @userid`
+	args := pgx.NamedArgs{
+		"jobid":  jobid,
+		"kind":   kind,
+		"userid": ctx.Value("userid"),
+	}
+	rows, err := c.conn.Query(ctx, query, args)
+	if err != nil {
+		return Job{}, fmt.Errorf("unable to query job: %w", err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return Job{}, fmt.Errorf("job didn't exist")
+	}
+	return scanJob(rows)
+}
+
+// Незавершённые задания всех пользователей
+func (c *Connection) GetActiveJobs(ctx context.Context) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs where status = any(@active) order by createdtime`
+	rows, err := c.conn.Query(ctx, query, pgx.NamedArgs{"active": activeJobStatuses})
+	if err != nil {
+		return []Job{}, fmt.Errorf("unable to query jobs: %w", err)
+	}
+	defer rows.Close()
+	result := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return []Job{}, err
+		}
+		result = append(result, job)
+	}
+	return result, nil
+}
+
+// Сохраняет ход вычисления задания и его состояние. Завершённое задание не меняется.
+// reason - причина ошибки для состояния failed.
+func (c *Connection) UpdateJob(ctx context.Context, jobid string, status ExpressionStatus, progress json.RawMessage, reason *ExpressionError) error {
+	err := updateJob(ctx, c.conn, jobid, status, progress, reason)
+	if err != nil {
+		return err
+	}
+	if status != ExpressionRunning {
+		slog.Info(fmt.Sprintf("Changed job %s status to %s", jobid, status))
+	}
+	return nil
+}
+
+// Создаёт выражения следующего шага задания и сохраняет его ход вычисления в одной транзакции:
+// если задание уже завершено или какое-то выражение не создано, не сохраняется ничего.
+func (c *Connection) InsertJobExpressions(ctx context.Context, jobid string, exprs []Expression, progress json.RawMessage) error {
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if len(exprs) > 0 {
+		batch := &pgx.Batch{}
+		for _, expr := range exprs {
+			batch.Queue(insertExpressionQuery, insertExpressionArgs(ctx, expr))
+		}
+		results := tx.SendBatch(ctx, batch)
+		for range exprs {
+			_, err := results.Exec()
+			if err != nil {
+				results.Close()
+				return fmt.Errorf("unable to insert row: %w", err)
+			}
+		}
+		err = results.Close()
+		if err != nil {
+			return fmt.Errorf("unable to insert row: %w", err)
+		}
+	}
+	err = updateJob(ctx, tx, jobid, ExpressionRunning, progress, nil)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Запрос UpdateJob, который выполняется и вне транзакции, и внутри неё
+func updateJob(ctx context.Context, db interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}, jobid string, status ExpressionStatus, progress json.RawMessage, reason *ExpressionError) error {
+	query := `UPDATE jobs SET status = @status, progress = @progress, error = @error, errorcode = @errorcode WHERE jobid = @jobid and status = any(@active)`
+	args := pgx.NamedArgs{
+		"jobid":     jobid,
+		"status":    int(status),
+		"progress":  progress,
+		"active":    activeJobStatuses,
+		"error":     nil,
+		"errorcode": nil,
+	}
+	if reason != nil {
+		args["error"], args["errorcode"] = reason.Message, reason.Code
+	}
+	tag, err := db.Exec(ctx, query, args)
+	if err != nil {
+		return fmt.Errorf("unable to update row: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("job %s to %s: %w", jobid, status, ErrStatusTransition)
+	}
+	return nil
+}
+
+// Выражения задания: состояние, результат и причина ошибки
+func (c *Connection) GetJobExpressions(ctx context.Context, jobid string) (map[string]Expression, error) {
+	query := `SELECT expressionid, status, result, exactresult, error, errorcode, erroroperation FROM expressions where jobid = @jobid`
+	rows, err := c.conn.Query(ctx, query, pgx.NamedArgs{"jobid": jobid})
+	if err != nil {
+		return map[string]Expression{}, fmt.Errorf("unable to query expressions: %w", err)
+	}
+	defer rows.Close()
+	result := map[string]Expression{}
+	for rows.Next() {
+		expr := Expression{}
+		var status int
+		var errMessage, errCode, errOperation *string
+		err := rows.Scan(&expr.Uuid, &status, &expr.Result, &expr.ExactResult, &errMessage, &errCode, &errOperation)
+		if err != nil {
+			return map[string]Expression{}, fmt.Errorf("unable to scan row: %w", err)
+		}
+		expr.Status = ExpressionStatus(status)
+		expr.State = expr.Status.String()
+		expr.Error = expressionError(errMessage, errCode, errOperation)
+		result[expr.Uuid] = expr
+	}
+	return result, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Состояние выражения. Числовые коды хранятся в базе и возвращаются в поле status для обратной совместимости.
+type ExpressionStatus int
+
+const (
+	// Выражение добавлено и ждёт разбиения на операции
+	ExpressionPending ExpressionStatus = 0
+	// Выражение разбито на операции
+	ExpressionPlanned ExpressionStatus = 1
+	// Результат вычислен
+	ExpressionSucceeded ExpressionStatus = 2
+	// Операции выражения отправлены агентам. В поле status возвращается как 1: для старых клиентов это "вычисляется".
+	ExpressionRunning ExpressionStatus = 3
+	// Вычисление завершилось ошибкой
+	ExpressionFailed ExpressionStatus = -1
+	// Вычисление отменено пользователем
+	ExpressionCancelled ExpressionStatus = -2
+)
+
+var expressionStates = map[ExpressionStatus]string{
+	ExpressionPending:   "pending",
+	ExpressionPlanned:   "planned",
+	ExpressionRunning:   "running",
+	ExpressionSucceeded: "succeeded",
+	ExpressionFailed:    "failed",
+	ExpressionCancelled: "cancelled",
+}
+
+func (s ExpressionStatus) String() string {
+	if state, ok := expressionStates[s]; ok {
+		return state
+	}
+	return "unknown"
+}
+
+// Код для поля status: running сообщается как planned, остальные коды совпадают с кодами в базе
+func (s ExpressionStatus) Code() int {
+	if s == ExpressionRunning {
+		return int(ExpressionPlanned)
+	}
+	return int(s)
+}
+
+func (s ExpressionStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Code())
+}
+
+// Состояния выражений, операции которых ещё вычисляются
+var activeExpressionStatuses = []int{int(ExpressionPlanned), int(ExpressionRunning)}
+
+// Допустимые переходы: для каждого состояния - из каких состояний в него можно перейти.
+// Завершённое выражение (succeeded, failed, cancelled) больше не меняет состояние.
+var expressionTransitions = map[ExpressionStatus][]ExpressionStatus{
+	ExpressionPlanned: {ExpressionPending},
+	ExpressionRunning: {ExpressionPlanned},
+	// Результат может прийти раньше, чем выражение отмечено как running
+	ExpressionSucceeded: {ExpressionPlanned, ExpressionRunning},
+	ExpressionFailed:    {ExpressionPending, ExpressionPlanned, ExpressionRunning},
+	ExpressionCancelled: {ExpressionPending, ExpressionPlanned, ExpressionRunning},
+}
+
+// Состояние операции
+type OperationStatus int
+
+const (
+	// Операция ждёт операндов или отправки агенту
+	OperationWaiting OperationStatus = 0
+	// Операция отправлена агенту
+	OperationSent OperationStatus = 1
+	// Результат передан родительским операциям
+	OperationDone OperationStatus = 2
+	// Агент вернул ошибку
+	OperationFailed OperationStatus = -1
+	// Операция ветви условной операции ждёт выбора ветви
+	OperationDormant OperationStatus = 3
+)
+
+var operationStates = map[OperationStatus]string{
+	OperationWaiting: "waiting",
+	OperationSent:    "sent",
+	OperationDone:    "done",
+	OperationFailed:  "failed",
+	OperationDormant: "dormant",
+}
+
+func (s OperationStatus) String() string {
+	if state, ok := operationStates[s]; ok {
+		return state
+	}
+	return "unknown"
+}
+
+var operationTransitions = map[OperationStatus][]OperationStatus{
+	OperationSent: {OperationWaiting},
+	// Зависшая у агента операция отправляется заново, спящая - запускается выбором её ветви
+	OperationWaiting: {OperationSent, OperationDormant},
+	OperationDone:    {OperationSent},
+	// Ошибка может прийти после того, как зависшая операция возвращена в ожидание
+	OperationFailed: {OperationWaiting, OperationSent},
+}
+
+var ErrStatusTransition = errors.New("status transition is not allowed")
+
+// Коды состояний, из которых можно перейти в to, для условия status = any(@from)
+func expressionSources(to ExpressionStatus) []int {
+	res := make([]int, 0, len(expressionTransitions[to]))
+	for _, from := range expressionTransitions[to] {
+		res = append(res, int(from))
+	}
+	return res
+}
+
+func operationSources(to OperationStatus) []int {
+	res := make([]int, 0, len(operationTransitions[to]))
+	for _, from := range operationTransitions[to] {
+		res = append(res, int(from))
+	}
+	return res
+}
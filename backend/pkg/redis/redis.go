@@ -31,13 +31,7 @@ func CloseConnectionRedis(cr *ConnectionRedis) {
 func (cr *ConnectionRedis) BulkSetOperationsTimeouts(timeouts map[string]int, userid int) error {
 	ctx := context.Background()
 	for key, value := range timeouts {
-		flag := true
-		for _, v := range []string{"+", "-", "/", "*"} {
-			if key == v {
-				flag = false
-			}
-		}
-		if flag {
+		if !calc.IsOperator(key) {
 			continue
 		}
 		err := cr.conn.HSet(ctx, "operationTimeouts_"+strconv.Itoa(userid), key, value*int(time.Second.Nanoseconds())).Err()
@@ -54,10 +48,16 @@ func (cr *ConnectionRedis) GetOperationsTimeouts(userid int) (map[string]time.Du
 		return map[string]time.Duration{}, cmd.Err()
 	}
 	timeouts := make(map[string]time.Duration, 0)
+	for _, op := range calc.Operators() {
+		timeouts[op] = calc.DefaultTimeout
+	}
 	for k, v := range cmd.Val() {
+		if !calc.IsOperator(k) {
+			continue
+		}
 		t, err := time.ParseDuration(v + "ns")
 		if err != nil {
-			t = time.Second * 10
+			t = calc.DefaultTimeout
 		}
 		timeouts[k] = t
 	}
@@ -100,7 +100,12 @@ func (cr *ConnectionRedis) GetSubscribe(channleName string) *redis.PubSub {
 
 func (cr *ConnectionRedis) SendOperationResult(operation struct {
 	OperationID string
-	Res         float64
+	Res         interface{}
+	Error       string
+	ErrorCode   string
+	WorkerName  string
+	StartedAt   time.Time
+	FinishedAt  time.Time
 }) error {
 	p, err := json.Marshal(operation)
 	if err != nil {
@@ -113,7 +118,7 @@ func (cr *ConnectionRedis) SendOperationResult(operation struct {
 	return nil
 }
 
-func (cr *ConnectionRedis) SetWorkerStatus(ctx context.Context, worker string, taskCount int) error {
+func (cr *ConnectionRedis) SetWorkerStatus(ctx context.Context, worker string, taskCount int, taskCountMax int) error {
 	err := cr.conn.HSet(ctx, "workers", worker, time.Now().Format(time.RFC3339Nano)).Err()
 	if err != nil {
 		return err
@@ -122,34 +127,51 @@ func (cr *ConnectionRedis) SetWorkerStatus(ctx context.Context, worker string, t
 	if err != nil {
 		return err
 	}
+	err = cr.conn.HSet(ctx, "workersTaskCountMax", worker, taskCountMax).Err()
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
 func (cr *ConnectionRedis) GetWorkersStatus(ctx context.Context) ([]struct {
-	WorkerName string `json:"workerName"`
-	Status     string `json:"status"`
-	TaskCount  string `json:"taskCount"`
+	WorkerName   string `json:"workerName"`
+	Status       string `json:"status"`
+	TaskCount    string `json:"taskCount"`
+	TaskCountMax string `json:"taskCountMax"`
 }, error) {
 	workerTime := cr.conn.HGetAll(ctx, "workers")
 	if workerTime.Err() != nil {
 		return []struct {
-			WorkerName string `json:"workerName"`
-			Status     string `json:"status"`
-			TaskCount  string `json:"taskCount"`
+			WorkerName   string `json:"workerName"`
+			Status       string `json:"status"`
+			TaskCount    string `json:"taskCount"`
+			TaskCountMax string `json:"taskCountMax"`
 		}{}, workerTime.Err()
 	}
 	workerTaskCount := cr.conn.HGetAll(ctx, "workersTaskCount")
 	if workerTaskCount.Err() != nil {
 		return []struct {
-			WorkerName string `json:"workerName"`
-			Status     string `json:"status"`
-			TaskCount  string `json:"taskCount"`
+			WorkerName   string `json:"workerName"`
+			Status       string `json:"status"`
+			TaskCount    string `json:"taskCount"`
+			TaskCountMax string `json:"taskCountMax"`
 		}{}, workerTime.Err()
 	}
+	workerTaskCountMax := cr.conn.HGetAll(ctx, "workersTaskCountMax")
+	if workerTaskCountMax.Err() != nil {
+		return []struct {
+			WorkerName   string `json:"workerName"`
+			Status       string `json:"status"`
+			TaskCount    string `json:"taskCount"`
+			TaskCountMax string `json:"taskCountMax"`
+		}{}, workerTaskCountMax.Err()
+	}
 	workerStatus := make([]struct {
-		WorkerName string `json:"workerName"`
-		Status     string `json:"status"`
-		TaskCount  string `json:"taskCount"`
+		WorkerName   string `json:"workerName"`
+		Status       string `json:"status"`
+		TaskCount    string `json:"taskCount"`
+		TaskCountMax string `json:"taskCountMax"`
 	}, 0)
 	for k, v := range workerTime.Val() {
 		status := "OK"
@@ -162,10 +184,11 @@ func (cr *ConnectionRedis) GetWorkersStatus(ctx context.Context) ([]struct {
 			status = "NO RESPONSE"
 		}
 		tmp := struct {
-			WorkerName string `json:"workerName"`
-			Status     string `json:"status"`
-			TaskCount  string `json:"taskCount"`
-		}{WorkerName: k, Status: status, TaskCount: workerTaskCount.Val()[k]}
+			WorkerName   string `json:"workerName"`
+			Status       string `json:"status"`
+			TaskCount    string `json:"taskCount"`
+			TaskCountMax string `json:"taskCountMax"`
+		}{WorkerName: k, Status: status, TaskCount: workerTaskCount.Val()[k], TaskCountMax: workerTaskCountMax.Val()[k]}
 		workerStatus = append(workerStatus, tmp)
 	}
 	return workerStatus, nil
@@ -12,27 +12,96 @@ create table public.users
 alter table public.users
     owner to orchestrator;
 
+create table public.jobs
+(
+    jobid       uuid not null
+        constraint jobs_pk
+            primary key,
+    kind        text not null,
+    userid      integer
+        constraint jobs_users_id_fk
+            references public.users,
+    expression  text not null,
+    variable    text not null,
+    variables   jsonb,
+    balance     boolean default false not null,
+    params      jsonb not null,
+    progress    jsonb,
+    status      integer,
+    error       text,
+    errorcode   text,
+    createdtime timestamp with time zone
+);
+
+comment on column public.jobs.kind is 'Вид задания: root - поиск корня, sweep - перебор значений переменной';
+
+comment on column public.jobs.expression is 'Шаблон выражения в канонической инфиксной записи';
+
+comment on column public.jobs.variable is 'Переменная, значения которой подставляет оркестратор';
+
+comment on column public.jobs.variables is 'Значения остальных переменных шаблона';
+
+comment on column public.jobs.params is 'Параметры задания (зависят от вида)';
+
+comment on column public.jobs.progress is 'Ход вычисления задания (зависит от вида)';
+
+comment on column public.jobs.status is 'Статус задания (коды статусов выражений)';
+
+comment on column public.jobs.error is 'Причина ошибки задания (статус -1)';
+
+alter table public.jobs
+    owner to orchestrator;
+
 create table public.expressions
 (
-    expressionid uuid not null
+    expressionid   uuid not null
         constraint expressions_pk
             primary key,
-    expression   text not null,
-    status       integer,
-    result       double precision,
-    userid       integer
+    expression     text not null,
+    status         integer,
+    result         double precision,
+    userid         integer
         constraint expressions_users_id_fk
-            references public.users
+            references public.users,
+    variables      jsonb,
+    mode           text default 'float' not null,
+    exactresult    text,
+    balance        boolean default false not null,
+    format         text default 'infix' not null,
+    error          text,
+    errorcode      text,
+    erroroperation uuid,
+    jobid          uuid
+        constraint expressions_jobs_fk
+            references public.jobs
 );
 
 comment on column public.expressions.expressionid is 'UUID запроса';
 
-comment on column public.expressions.expression is 'Выражение';
+comment on column public.expressions.expression is 'Выражение в канонической инфиксной записи';
 
 comment on column public.expressions.status is 'Статус выражения';
 
 comment on column public.expressions.result is 'Результат вычислений';
 
+comment on column public.expressions.variables is 'Значения переменных выражения';
+
+comment on column public.expressions.mode is 'Режим вычисления: float или exact (точные дроби)';
+
+comment on column public.expressions.exactresult is 'Точный результат в виде дроби (режим exact)';
+
+comment on column public.expressions.balance is 'Перестраивать цепочки + и * в сбалансированные деревья';
+
+comment on column public.expressions.format is 'Формат, в котором выражение было отправлено: infix, rpn, prefix или latex (выражение хранится в канонической инфиксной записи)';
+
+comment on column public.expressions.error is 'Причина ошибки вычисления (статус -1)';
+
+comment on column public.expressions.errorcode is 'Код ошибки вычисления: invalid_expression, division_by_zero, overflow, ...';
+
+comment on column public.expressions.erroroperation is 'UUID операции, при выполнении которой произошла ошибка';
+
+comment on column public.expressions.jobid is 'UUID задания, для которого оркестратор создал выражение';
+
 alter table public.expressions
     owner to orchestrator;
 
@@ -42,27 +111,54 @@ create table public.operations
         constraint operationid_pk
             primary key,
     operator     text not null,
-    v1           double precision,
-    v2           double precision,
+    args         text[] not null,
     expressionid uuid not null
         constraint expressionid_fk
             references public.expressions,
-    parentid     uuid,
-    "left"       boolean,
-    result       double precision,
+    parentids    uuid[] not null,
+    positions    integer[] not null,
+    guard        uuid,
+    branch       integer,
+    chosenbranch integer,
+    result       text,
     status       integer,
-    changedtime  timestamp
+    changedtime  timestamp with time zone,
+    mode         text default 'float' not null,
+    error        text,
+    queuedtime   timestamp with time zone,
+    worker       text,
+    startedtime  timestamp with time zone,
+    finishedtime timestamp with time zone,
+    attempts     integer default 0 not null
 );
 
 comment on column public.operations.operationid is 'UUID элементарного выражения';
 
-comment on column public.operations.v1 is 'Левое значение';
+comment on column public.operations.args is 'Операнды (NULL - результат дочерней операции ещё не получен)';
+
+comment on column public.operations.result is 'Результат: число или дробь в режиме exact';
+
+comment on column public.operations.parentids is 'UUID родительских операций (для корневой операции - UUID выражения)';
+
+comment on column public.operations.guard is 'UUID условной операции, выбор ветви которой запускает операцию';
+
+comment on column public.operations.branch is 'Номер ветви условной операции: 1 - then, 2 - else';
+
+comment on column public.operations.chosenbranch is 'Выбранная ветвь условной операции (NULL - условие ещё не вычислено)';
+
+comment on column public.operations.error is 'Текст ошибки выполнения операции (статус -1)';
+
+comment on column public.operations.queuedtime is 'Время последней отправки операции в очередь агентов';
+
+comment on column public.operations.worker is 'Имя агента, выполнившего операцию';
+
+comment on column public.operations.startedtime is 'Время начала вычисления операции на агенте';
 
-comment on column public.operations.v2 is 'Правое значение';
+comment on column public.operations.finishedtime is 'Время окончания вычисления операции на агенте';
 
-comment on column public.operations.parentid is 'UUID родительской опреации';
+comment on column public.operations.attempts is 'Сколько раз зависшая операция возвращалась в ожидание для повторной отправки';
 
-comment on column public.operations."left" is 'Левый операнд родительского выражения?';
+comment on column public.operations.positions is 'Номера операндов родительских операций (с 0), по одному на каждого родителя';
 
 alter table public.operations
     owner to orchestrator;